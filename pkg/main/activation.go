@@ -1,6 +1,18 @@
 package main
 
 type Activation struct {
-	Frames int
-	Value  float64
+	Frames int     `json:"frames"`
+	Value  float64 `json:"value"`
+	// the largest area of the activation, and how many frames before the end of the activation it was seen
+	PeakValue       float64 `json:"peakValue"`
+	FramesSincePeak int     `json:"framesSincePeak"`
 }
+
+// reasons for a gate activation not becoming a detection
+const (
+	RejectedLowActivationValue  = "low_activation_value"
+	RejectedFewActivationFrames = "few_activation_frames"
+	RejectedTooSoon             = "too_soon"
+	RejectedRaceWaiting         = "race_waiting"
+	RejectedRaceFinished        = "race_finished"
+)
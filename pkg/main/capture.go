@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// Capture reads frames from a video file or stream, reopening a stream when it fails
+type Capture struct {
+	path              string
+	reconnectAttempts int
+	reconnectDelay    time.Duration
+	metrics           *Metrics
+
+	Logger *slog.Logger
+
+	_capture *gocv.VideoCapture
+}
+
+func NewCapture(path string, reconnectAttempts int, reconnectDelayMillis int, metrics *Metrics) (*Capture, error) {
+	capture, err := gocv.OpenVideoCapture(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open video %s. %s", path, err.Error())
+	}
+
+	return &Capture{
+		path:              path,
+		reconnectAttempts: reconnectAttempts,
+		reconnectDelay:    time.Duration(reconnectDelayMillis) * time.Millisecond,
+		metrics:           metrics,
+		Logger:            discardLogger,
+		_capture:          capture,
+	}, nil
+}
+
+// IsStream is true for network sources (e.g. rtsp://), files end instead of failing
+func (c *Capture) IsStream() bool {
+	return strings.Contains(c.path, "://")
+}
+
+// Read skips frames that cannot be decoded, and returns false at the end of the video, or when the stream is gone
+func (c *Capture) Read(img *gocv.Mat) bool {
+	for {
+		ok := c._capture.Read(img)
+		if ok && !img.Empty() {
+			return true
+		}
+
+		if !ok && !c.IsStream() {
+			// end of file
+			return false
+		}
+
+		c.metrics.FramesDropped.Inc()
+
+		if ok {
+			continue
+		}
+
+		if !c.reconnect() {
+			return false
+		}
+	}
+}
+
+// Seek makes the next Read return the frame with the given index, it's not possible in a stream
+func (c *Capture) Seek(frame uint64) error {
+	if c.IsStream() {
+		return fmt.Errorf("cannot seek in video stream %s", c.path)
+	}
+	c._capture.Set(gocv.VideoCapturePosFrames, float64(frame))
+	return nil
+}
+
+func (c *Capture) reconnect() bool {
+	_ = c._capture.Close()
+
+	for attempt := 1; attempt <= c.reconnectAttempts; attempt++ {
+		c.Logger.Warn("video stream failed, reconnecting", "path", c.path, "attempt", attempt, "attempts", c.reconnectAttempts)
+		time.Sleep(c.reconnectDelay)
+
+		capture, err := gocv.OpenVideoCapture(c.path)
+		if err == nil && capture.IsOpened() {
+			c._capture = capture
+			c.metrics.CaptureReconnects.Inc()
+			return true
+		}
+		if capture != nil {
+			_ = capture.Close()
+		}
+	}
+
+	c.Logger.Error("video stream could not be reopened", "path", c.path)
+	return false
+}
+
+func (c *Capture) Close() error {
+	if err := c._capture.Close(); err != nil {
+		return fmt.Errorf("could not close video %s. %s", c.path, err.Error())
+	}
+	return nil
+}
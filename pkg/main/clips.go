@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"fmt"
+	"gocv.io/x/gocv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Clip is a part of the source video, from StartFrame up to and including StopFrame
+type Clip struct {
+	Name       string
+	StartFrame uint64
+	StopFrame  uint64
+}
+
+// Clips lists the clips to cut for the best lap, each lap, and each detection, with the given padding (in frames)
+// before and after. The caller must hold the timer lock.
+func (t *Timer) Clips(bestLap bool, laps bool, detections bool, lapPadding int, detectionPadding int) []*Clip {
+	var clips []*Clip
+
+	padded := func(name string, start uint64, stop uint64, padding int) *Clip {
+		if start > uint64(padding) {
+			start -= uint64(padding)
+		} else {
+			start = 0
+		}
+		return &Clip{Name: name, StartFrame: start, StopFrame: stop + uint64(padding)}
+	}
+
+	if bestLap {
+		if best := t.Statistics(1).Best; best != nil {
+			name := fmt.Sprintf("best-lap-%02d-%s", t.LapNumber(best), clipTime(t.Duration(best.Frames())))
+			clips = append(clips, padded(name, best.startFrame, best.stopFrame, lapPadding))
+		}
+	}
+
+	if laps {
+		for i, lap := range t.Laps {
+			name := fmt.Sprintf("lap-%02d-%s", i+1, clipTime(t.Duration(lap.Frames())))
+			if !lap.IsValid() {
+				name = fmt.Sprintf("%s-%s", name, lap.Status)
+			}
+			clips = append(clips, padded(name, lap.startFrame, lap.stopFrame, lapPadding))
+		}
+	}
+
+	if detections {
+		for _, detection := range t.DetectionsInOrder {
+			if detection.IsInferred() {
+				// nothing to see
+				continue
+			}
+			name := fmt.Sprintf("detection-%03d-%s-%s", detection.ID, detection.Gate.Name, clipTime(t.Duration(int(detection.FrameOffset))))
+			clips = append(clips, padded(name, detection.FrameOffset, detection.FrameOffset, detectionPadding))
+		}
+	}
+
+	return clips
+}
+
+// clipTime formats a duration for file names, e.g. 1m02.345s
+func clipTime(d time.Duration) string {
+	return fmt.Sprintf("%dm%06.3fs", int(d.Minutes()), (d % time.Minute).Seconds())
+}
+
+// ClipExtension is the file extension of the clips, that of the output video when it's set,
+// or else the usual container of the codec
+func ClipExtension(outputPath string, codec string) string {
+	if ext := filepath.Ext(outputPath); ext != "" {
+		return ext
+	}
+
+	switch strings.ToUpper(codec) {
+	case "MJPG", "XVID", "DIVX", "DX50", "FMP4", "IYUV", "I420":
+		return ".avi"
+	}
+	return ".mp4"
+}
+
+// ExtractClips cuts the clips from the video into the directory, as <name><extension>.
+// The frame offsets of the clips are relative to firstFrame, the offset of the first frame of the video.
+func ExtractClips(videoPath string, dir string, codec string, extension string, framesPerSec int, firstFrame uint64, clips []*Clip) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("could not create clips directory %s. %s", dir, err.Error())
+	}
+
+	video, err := gocv.OpenVideoCapture(videoPath)
+	if err != nil {
+		return fmt.Errorf("could not open video %s. %s", videoPath, err.Error())
+	}
+	defer video.Close()
+
+	width := int(video.Get(gocv.VideoCaptureFrameWidth))
+	height := int(video.Get(gocv.VideoCaptureFrameHeight))
+
+	// in video order, so that most seeks are forward
+	sorted := append([]*Clip{}, clips...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].StartFrame < sorted[j].StartFrame
+	})
+
+	img := gocv.NewMat()
+	defer img.Close()
+
+	for _, clip := range sorted {
+		if clip.StopFrame < firstFrame {
+			// not in this video
+			continue
+		}
+
+		start := uint64(0)
+		if clip.StartFrame > firstFrame {
+			start = clip.StartFrame - firstFrame
+		}
+		stop := clip.StopFrame - firstFrame
+
+		path := filepath.Join(dir, clipFileName(clip.Name)+extension)
+		writer, err := gocv.VideoWriterFile(path, codec, float64(framesPerSec), width, height, true)
+		if err != nil {
+			return fmt.Errorf("could not create clip %s. %s", path, err.Error())
+		}
+
+		video.Set(gocv.VideoCapturePosFrames, float64(start))
+		for frame := start; frame <= stop; frame++ {
+			if ok := video.Read(&img); !ok || img.Empty() {
+				break
+			}
+			if err = writer.Write(img); err != nil {
+				_ = writer.Close()
+				return fmt.Errorf("could not write clip %s. %s", path, err.Error())
+			}
+		}
+
+		if err = writer.Close(); err != nil {
+			return fmt.Errorf("could not close clip %s. %s", path, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func clipFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+}
@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import "fmt"
+
+const (
+	CommandReset     = "reset"
+	CommandStart     = "start"
+	CommandStop      = "stop"
+	CommandDetection = "detection"
+)
+
+// Command is a race control request coming from outside the frame loop (e.g. a race director tool)
+type Command struct {
+	Name string `json:"command"`
+	// only for detection commands
+	Gate string `json:"gate,omitempty"`
+	// defaults to the current frame
+	FrameOffset *uint64 `json:"frameOffset,omitempty"`
+	Author      string  `json:"author,omitempty"`
+	Reason      string  `json:"reason,omitempty"`
+}
+
+// InvalidCommandError is returned for a command that can never be executed (e.g. for an unknown gate),
+// as opposed to one that conflicts with the state of the race
+type InvalidCommandError struct {
+	Reason string
+}
+
+func (e *InvalidCommandError) Error() string {
+	return e.Reason
+}
+
+// Execute applies the command to the timer, the caller must hold the timer lock
+func (t *Timer) Execute(command *Command) (*Detection, error) {
+	frameOffset := t.CurrentFrame
+	if command.FrameOffset != nil {
+		frameOffset = *command.FrameOffset
+	}
+
+	switch command.Name {
+	case CommandReset:
+		t.Reset(command.Author, command.Reason)
+		return nil, nil
+	case CommandStart:
+		if t.Finished {
+			t.Reset(command.Author, command.Reason)
+		}
+		if !t.StartRace(frameOffset, RaceStartManual) {
+			return nil, fmt.Errorf("the race has already started")
+		}
+		return nil, nil
+	case CommandStop:
+		if t.Finished {
+			return nil, fmt.Errorf("the race has already finished")
+		}
+		t.FinishRace(frameOffset)
+		return nil, nil
+	case CommandDetection:
+		if _, ok := t.GatesByName[command.Gate]; !ok {
+			return nil, &InvalidCommandError{Reason: fmt.Sprintf("unknown gate %s", command.Gate)}
+		}
+		return t.InsertDetection(command.Gate, frameOffset, command.Author, command.Reason)
+	}
+
+	return nil, &InvalidCommandError{Reason: fmt.Sprintf("unknown command %q", command.Name)}
+}
@@ -11,6 +11,7 @@ import (
 	"gopkg.in/yaml.v3"
 	"io"
 	"os"
+	"regexp"
 )
 
 type PropellerMaskConfig struct {
@@ -36,9 +37,181 @@ type GateConfig struct {
 	Color     GateColorConfig     `json:"color"`
 }
 
+type RaceStartMotionConfig struct {
+	MinPixels int `json:"minPixels"`
+}
+
+type RaceStartVisualConfig struct {
+	LowerBoundHSV []int `json:"lowerBoundHSV"`
+	UpperBoundHSV []int `json:"upperBoundHSV"`
+	MinArea       int   `json:"minArea"`
+}
+
+type RaceStartConfig struct {
+	Mode         string                `json:"mode"`
+	OffsetMillis int                   `json:"offsetMillis"`
+	Motion       RaceStartMotionConfig `json:"motion"`
+	Visual       RaceStartVisualConfig `json:"visual"`
+}
+
+type RaceConfig struct {
+	Start             RaceStartConfig `json:"start"`
+	FirstLapFromStart bool            `json:"firstLapFromStart"`
+}
+
+type TrackConfig struct {
+	Sequence []string `json:"sequence"`
+}
+
+type InferenceConfig struct {
+	AnomalyFactor        float64 `json:"anomalyFactor"`
+	SplitMissedStartGate bool    `json:"splitMissedStartGate"`
+}
+
+type OverlayPageConfig struct {
+	Theme  string `json:"theme"`
+	Accent string `json:"accent"`
+}
+
+type ServerConfig struct {
+	Address string `json:"address"`
+	// number of recent events replayed to newly connected event stream clients
+	EventHistory int               `json:"eventHistory"`
+	Overlay      OverlayPageConfig `json:"overlay"`
+}
+
+type OverlayElementConfig struct {
+	// laps, transition, latency, holeshot or splits
+	Content string `json:"content"`
+	// where the text starts, on its baseline, as a fraction (0-1) of the frame width and height
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	// left, center or right of x
+	Align string `json:"align"`
+	// multiplies the overlay font scale, 1 when not set
+	FontScale float64 `json:"fontScale"`
+	// #rrggbb, the overlay color when not set
+	Color      string `json:"color"`
+	Background bool   `json:"background"`
+}
+
+type OverlayConfig struct {
+	// for a 720 pixel high frame, scaled to the frame height
+	FontScale         float64                `json:"fontScale"`
+	Color             string                 `json:"color"`
+	BackgroundColor   string                 `json:"backgroundColor"`
+	BackgroundOpacity float64                `json:"backgroundOpacity"`
+	Elements          []OverlayElementConfig `json:"elements"`
+}
+
+type LogConfig struct {
+	// text or json
+	Format string `json:"format"`
+	// debug, info, warn or error
+	Level string `json:"level"`
+}
+
+type OutputConfig struct {
+	// path of the annotated video, no video is written when empty
+	Video string `json:"video"`
+	// FourCC, e.g. mp4v or avc1
+	Codec       string `json:"codec"`
+	Mask        bool   `json:"mask"`
+	Graph       bool   `json:"graph"`
+	FlashMillis int    `json:"flashMillis"`
+}
+
+type ClipsConfig struct {
+	// directory the clips are written to, no clips are cut when empty
+	Dir                    string `json:"dir"`
+	BestLap                bool   `json:"bestLap"`
+	Laps                   bool   `json:"laps"`
+	Detections             bool   `json:"detections"`
+	LapPaddingMillis       int    `json:"lapPaddingMillis"`
+	DetectionPaddingMillis int    `json:"detectionPaddingMillis"`
+}
+
+type GalleryConfig struct {
+	// directory the detection thumbnails are saved to, no gallery is created when empty
+	Dir string `json:"dir"`
+	// thumbnails per detection, centered on the peak
+	Frames        int `json:"frames"`
+	SpacingMillis int `json:"spacingMillis"`
+}
+
+type DebugConfig struct {
+	// shows the debug view while the video is processed
+	View bool `json:"view"`
+	// how much of the signal is plotted
+	Seconds int `json:"seconds"`
+}
+
+type PlaylistConfig struct {
+	// the videos are one recording split into files (e.g. by the goggles), so laps continue from one to the next.
+	// Otherwise each video is a separate recording, and laps across videos are invalid.
+	Continuous bool `json:"continuous"`
+}
+
+type RangeConfig struct {
+	// from the start of the video
+	StartMillis int `json:"startMillis"`
+	// 0 for the end of the video
+	EndMillis int `json:"endMillis"`
+}
+
+type CaptureConfig struct {
+	// only for streams, e.g. rtsp://
+	ReconnectAttempts    int `json:"reconnectAttempts"`
+	ReconnectDelayMillis int `json:"reconnectDelayMillis"`
+}
+
+type HookConfig struct {
+	// event types that trigger the hook, all events when empty
+	Events        []string `json:"events"`
+	Command       []string `json:"command"`
+	URL           string   `json:"url"`
+	TimeoutMillis int      `json:"timeoutMillis"`
+}
+
+type MQTTTopicsConfig struct {
+	// events are published to <events>/<type>, e.g. fpv-blob-timer/events/lap
+	Events  string `json:"events"`
+	State   string `json:"state"`
+	Control string `json:"control"`
+}
+
+type MQTTConfig struct {
+	Broker   string           `json:"broker"`
+	ClientID string           `json:"clientId"`
+	Username string           `json:"username,omitempty"`
+	Password string           `json:"password,omitempty"`
+	QoS      int              `json:"qos"`
+	Topics   MQTTTopicsConfig `json:"topics"`
+}
+
+type StatisticsConfig struct {
+	ConsecutiveLaps int `json:"consecutiveLaps"`
+}
+
 type Config struct {
 	FramesPerSec  int                 `json:"framesPerSec"`
+	Log           LogConfig           `json:"log"`
 	PropellerMask PropellerMaskConfig `json:"propellerMask"`
+	Capture       CaptureConfig       `json:"capture"`
+	Ranges        []RangeConfig       `json:"ranges"`
+	Playlist      PlaylistConfig      `json:"playlist"`
+	Overlay       OverlayConfig       `json:"overlay"`
+	Output        OutputConfig        `json:"output"`
+	Clips         ClipsConfig         `json:"clips"`
+	Gallery       GalleryConfig       `json:"gallery"`
+	Debug         DebugConfig         `json:"debug"`
+	Race          RaceConfig          `json:"race"`
+	Statistics    StatisticsConfig    `json:"statistics"`
+	Track         TrackConfig         `json:"track"`
+	Inference     InferenceConfig     `json:"inference"`
+	Server        ServerConfig        `json:"server"`
+	Hooks         []HookConfig        `json:"hooks"`
+	MQTT          MQTTConfig          `json:"mqtt"`
 	Gates         []GateConfig        `json:"gates"`
 }
 
@@ -73,10 +246,290 @@ func NewConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("could not parse config file. %s", err.Error())
 	}
 
-	config := Config{}
-	if err = json.Unmarshal(configJson, &config); err != nil {
-		return nil, fmt.Errorf("could not parse config file. %s", err.Error())
+	var config *Config
+	if config, err = ParseConfig(configJson); err != nil {
+		return nil, fmt.Errorf("invalid config file. %s", err.Error())
+	}
+
+	return config, nil
+}
+
+// DefaultConfig is the config with the defaults of the settings that are left out of a config file
+func DefaultConfig() *Config {
+	return &Config{
+		Log: LogConfig{
+			Format: LogFormatText,
+			Level:  "info",
+		},
+		Capture: CaptureConfig{
+			ReconnectAttempts:    10,
+			ReconnectDelayMillis: 1000,
+		},
+		Overlay: OverlayConfig{
+			FontScale:         1,
+			Color:             "#ffffff",
+			BackgroundColor:   "#000000",
+			BackgroundOpacity: 0.5,
+		},
+		Output: OutputConfig{
+			Codec:       "mp4v",
+			Mask:        true,
+			Graph:       true,
+			FlashMillis: 500,
+		},
+		Clips: ClipsConfig{
+			BestLap:                true,
+			Laps:                   true,
+			Detections:             true,
+			LapPaddingMillis:       1000,
+			DetectionPaddingMillis: 1000,
+		},
+		Gallery: GalleryConfig{
+			Frames:        5,
+			SpacingMillis: 100,
+		},
+		Playlist: PlaylistConfig{
+			Continuous: true,
+		},
+		Debug: DebugConfig{
+			Seconds: 10,
+		},
+		Race: RaceConfig{
+			Start: RaceStartConfig{
+				Mode: RaceStartGate,
+				Motion: RaceStartMotionConfig{
+					MinPixels: 2000,
+				},
+				Visual: RaceStartVisualConfig{
+					MinArea: 2000,
+				},
+			},
+		},
+		Statistics: StatisticsConfig{
+			ConsecutiveLaps: 3,
+		},
+		Inference: InferenceConfig{
+			AnomalyFactor: 1.6,
+		},
+		Server: ServerConfig{
+			EventHistory: 100,
+			Overlay: OverlayPageConfig{
+				Theme:  "dark",
+				Accent: "#ff2d95",
+			},
+		},
+		MQTT: MQTTConfig{
+			ClientID: "fpv-blob-timer",
+			QoS:      1,
+			Topics: MQTTTopicsConfig{
+				Events:  "fpv-blob-timer/events",
+				State:   "fpv-blob-timer/state",
+				Control: "fpv-blob-timer/control",
+			},
+		},
+	}
+}
+
+// ParseConfig reads the JSON config over the defaults, and validates it.
+// Settings added after the config was written (e.g. the config of an older session file) keep their defaults.
+func ParseConfig(configJson []byte) (*Config, error) {
+	config, err := parseConfigDefaults(configJson)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// parseConfigDefaults parses the config over the defaults, without validating it
+func parseConfigDefaults(configJson []byte) (*Config, error) {
+	config := DefaultConfig()
+	if err := json.Unmarshal(configJson, config); err != nil {
+		return nil, fmt.Errorf("could not parse config. %s", err.Error())
+	}
+
+	// the default layout, only when the config has no overlay elements
+	if config.Overlay.Elements == nil {
+		config.Overlay.Elements = []OverlayElementConfig{
+			{Content: OverlayLaps, X: 0.234, Y: 0.139},
+			{Content: OverlayTransition, X: 0.234, Y: 0.208},
+			{Content: OverlayLatency, X: 0.234, Y: 0.278},
+			{Content: OverlayHoleshot, X: 0.234, Y: 0.347},
+			{Content: OverlaySplits, X: 0.234, Y: 0.417, FontScale: 0.8},
+		}
+	}
+
+	return config, nil
+}
+
+// cssColor matches the CSS colors the overlay page accepts: hex, named, and rgb() or hsl() colors
+var cssColor = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+|(rgb|rgba|hsl|hsla)\([0-9.,%/ a-z]+\))$`)
+
+func (c *Config) Validate() error {
+	if _, err := NewLogger(io.Discard, c.Log.Format, c.Log.Level); err != nil {
+		return err
+	}
+
+	if err := c.Overlay.Validate(); err != nil {
+		return err
+	}
+
+	if len(c.Output.Codec) != 4 {
+		return fmt.Errorf("output codec must be a FourCC, e.g. mp4v")
+	}
+
+	if c.Debug.View && c.Debug.Seconds <= 0 {
+		return fmt.Errorf("debug seconds must be greater than 0")
+	}
+
+	for i, r := range c.Ranges {
+		if r.StartMillis < 0 || r.EndMillis < 0 {
+			return fmt.Errorf("range %d must not be negative", i+1)
+		}
+		if r.EndMillis != 0 && r.EndMillis <= r.StartMillis {
+			return fmt.Errorf("range %d must end after it starts", i+1)
+		}
+	}
+
+	switch c.Race.Start.Mode {
+	case RaceStartGate, RaceStartTime, RaceStartVisual, RaceStartMotion:
+	default:
+		return fmt.Errorf("unknown race start mode %q", c.Race.Start.Mode)
+	}
+
+	if c.Race.Start.Mode == RaceStartVisual {
+		if err := c.Race.Start.Visual.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.ValidateSession(); err != nil {
+		return err
+	}
+
+	for i, hook := range c.Hooks {
+		if (len(hook.Command) == 0) == (hook.URL == "") {
+			return fmt.Errorf("hook %d must have either a command or a url", i+1)
+		}
+		if hook.TimeoutMillis <= 0 {
+			return fmt.Errorf("hook %d must have a timeout", i+1)
+		}
+	}
+
+	if !cssColor.MatchString(c.Server.Overlay.Accent) {
+		return fmt.Errorf("server overlay accent %q is not a CSS color, e.g. #ff2d95 or hotpink", c.Server.Overlay.Accent)
+	}
+
+	if c.MQTT.QoS < 0 || c.MQTT.QoS > 2 {
+		return fmt.Errorf("mqtt qos must be 0, 1 or 2")
+	}
+
+	return nil
+}
+
+// ValidateSession checks only the gates and track, which restoring, exporting and reviewing a saved session rely on.
+// The capture settings of a session snapshot are not checked, so that sessions saved before a rule was added still load.
+func (c *Config) ValidateSession() error {
+	gateNames := map[string]bool{}
+	for _, gate := range c.Gates {
+		if len(gate.Color.LowerBoundHSV) != 3 || len(gate.Color.UpperBoundHSV) != 3 {
+			return fmt.Errorf("gate %s color bounds must have 3 HSV values", gate.Name)
+		}
+		gateNames[gate.Name] = true
+	}
+
+	for _, name := range c.Track.Sequence {
+		if !gateNames[name] {
+			return fmt.Errorf("track sequence has unknown gate %s", name)
+		}
+	}
+
+	if len(c.Track.Sequence) > 0 && len(c.Gates) > 0 && c.Track.Sequence[0] != c.Gates[0].Name {
+		return fmt.Errorf("track sequence must begin with the start gate %s", c.Gates[0].Name)
+	}
+
+	// a lap ends at the next start gate pass, so a lap can never pass the start gate in between
+	for i, name := range c.Track.Sequence {
+		if i > 0 && name == c.Track.Sequence[0] {
+			return fmt.Errorf("track sequence must pass the start gate %s only at its beginning", name)
+		}
+	}
+
+	return nil
+}
+
+// Validate requires the bounds of the cue color, there is no default since any range matches some part of a real video
+func (c *RaceStartVisualConfig) Validate() error {
+	if len(c.LowerBoundHSV) != 3 || len(c.UpperBoundHSV) != 3 {
+		return fmt.Errorf("race start visual cue bounds must have 3 HSV values")
+	}
+
+	wide := false
+	for i := range c.LowerBoundHSV {
+		if c.LowerBoundHSV[i] > c.UpperBoundHSV[i] {
+			return fmt.Errorf("race start visual cue lower bound must not be above the upper bound")
+		}
+		wide = wide || c.LowerBoundHSV[i] < c.UpperBoundHSV[i]
+	}
+	if !wide {
+		return fmt.Errorf("race start visual cue bounds must be a range of colors, not a single color")
+	}
+
+	if c.MinArea <= 0 {
+		return fmt.Errorf("race start visual cue min area must be greater than 0")
+	}
+
+	return nil
+}
+
+func (c *OverlayConfig) Validate() error {
+	if c.FontScale <= 0 {
+		return fmt.Errorf("overlay font scale must be greater than 0")
+	}
+
+	if c.BackgroundOpacity < 0 || c.BackgroundOpacity > 1 {
+		return fmt.Errorf("overlay background opacity must be between 0 and 1")
+	}
+
+	for _, hex := range []string{c.Color, c.BackgroundColor} {
+		if _, err := ParseHexColor(hex); err != nil {
+			return fmt.Errorf("overlay %s", err.Error())
+		}
+	}
+
+	for i, element := range c.Elements {
+		known := false
+		for _, content := range OverlayContents {
+			known = known || element.Content == content
+		}
+		if !known {
+			return fmt.Errorf("overlay element %d has unknown content %q, must be one of %v", i+1, element.Content, OverlayContents)
+		}
+
+		switch element.Align {
+		case "", OverlayAlignLeft, OverlayAlignCenter, OverlayAlignRight:
+		default:
+			return fmt.Errorf("overlay element %d has unknown align %q", i+1, element.Align)
+		}
+
+		if element.X < 0 || element.X > 1 || element.Y < 0 || element.Y > 1 {
+			return fmt.Errorf("overlay element %d position must be between 0 and 1", i+1)
+		}
+
+		if element.FontScale < 0 {
+			return fmt.Errorf("overlay element %d font scale must not be negative", i+1)
+		}
+
+		if element.Color != "" {
+			if _, err := ParseHexColor(element.Color); err != nil {
+				return fmt.Errorf("overlay element %d %s", i+1, err.Error())
+			}
+		}
 	}
 
-	return &config, nil
+	return nil
 }
@@ -0,0 +1,40 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"testing"
+)
+
+func TestValidateTrackSequence(t *testing.T) {
+	tests := []struct {
+		name     string
+		sequence []string
+		valid    bool
+	}{
+		{"gates in order", []string{"start", "a", "b"}, true},
+		{"figure-8", []string{"start", "a", "b", "a", "b"}, true},
+		{"default order", nil, true},
+		{"unknown gate", []string{"start", "c"}, false},
+		{"not from the start gate", []string{"a", "start", "b"}, false},
+		{"start gate repeated", []string{"start", "a", "start", "b"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := testSessionConfig()
+			config.Gates = append(config.Gates, GateConfig{Name: "b", Color: config.Gates[0].Color})
+			config.Track.Sequence = test.sequence
+
+			err := config.Validate()
+			if test.valid && err != nil {
+				t.Errorf("got error %v", err)
+			}
+			if !test.valid && err == nil {
+				t.Errorf("got no error")
+			}
+		})
+	}
+}
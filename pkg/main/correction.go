@@ -0,0 +1,155 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	CorrectionInsert = "insert"
+	CorrectionRemove = "remove"
+	CorrectionMove   = "move"
+	// the race was reset, clearing all the detections
+	CorrectionReset = "reset"
+)
+
+// Correction is an audit log entry for a manual change to the detections, or a reset of the race
+type Correction struct {
+	Time        time.Time
+	Author      string
+	Action      string
+	DetectionID int
+	Gate        string
+	FromFrame   uint64
+	ToFrame     uint64
+	Reason      string
+}
+
+func (c *Correction) String() string {
+	switch c.Action {
+	case CorrectionInsert:
+		return fmt.Sprintf("%s inserted detection %d (gate: %s, frame: %v), %s", c.Author, c.DetectionID, c.Gate, c.ToFrame, c.Reason)
+	case CorrectionRemove:
+		return fmt.Sprintf("%s removed detection %d (gate: %s, frame: %v), %s", c.Author, c.DetectionID, c.Gate, c.FromFrame, c.Reason)
+	case CorrectionReset:
+		return fmt.Sprintf("%s reset the race (frame: %v), %s", c.Author, c.FromFrame, c.Reason)
+	default:
+		return fmt.Sprintf("%s moved detection %d (gate: %s) from frame %v to %v, %s", c.Author, c.DetectionID, c.Gate, c.FromFrame, c.ToFrame, c.Reason)
+	}
+}
+
+func (t *Timer) DetectionByID(id int) *Detection {
+	for _, detection := range t.DetectionsInOrder {
+		if detection.ID == id {
+			return detection
+		}
+	}
+	return nil
+}
+
+// InsertDetection adds a manual detection for the given gate, and recomputes laps and transitions
+func (t *Timer) InsertDetection(gateName string, frameOffset uint64, author string, reason string) (*Detection, error) {
+	gate, ok := t.GatesByName[gateName]
+	if !ok {
+		return nil, fmt.Errorf("unknown gate %s", gateName)
+	}
+	if err := t.checkRaceFrame(frameOffset); err != nil {
+		return nil, err
+	}
+
+	detection := &Detection{
+		ID:          t.nextDetectionID(),
+		Gate:        gate,
+		FrameOffset: frameOffset,
+		Origin:      DetectionOriginManual,
+	}
+	t.insertDetection(detection)
+	t.rebuild()
+
+	t.audit(CorrectionInsert, detection, 0, frameOffset, author, reason)
+	return detection, nil
+}
+
+// RemoveDetection deletes a detection (e.g. a false detection), and recomputes laps and transitions
+func (t *Timer) RemoveDetection(id int, author string, reason string) error {
+	detection := t.DetectionByID(id)
+	if detection == nil {
+		return fmt.Errorf("unknown detection %d", id)
+	}
+
+	t.removeDetection(detection)
+	t.rebuild()
+
+	t.audit(CorrectionRemove, detection, detection.FrameOffset, 0, author, reason)
+	return nil
+}
+
+// MoveDetection changes the frame of a detection, and recomputes laps and transitions
+func (t *Timer) MoveDetection(id int, frameOffset uint64, author string, reason string) error {
+	detection := t.DetectionByID(id)
+	if detection == nil {
+		return fmt.Errorf("unknown detection %d", id)
+	}
+	if err := t.checkRaceFrame(frameOffset); err != nil {
+		return err
+	}
+
+	fromFrame := detection.FrameOffset
+	t.removeDetection(detection)
+	detection.FrameOffset = frameOffset
+	t.insertDetection(detection)
+	t.rebuild()
+
+	t.audit(CorrectionMove, detection, fromFrame, frameOffset, author, reason)
+	return nil
+}
+
+// checkRaceFrame rejects a frame outside the race, where a detection would not be counted
+func (t *Timer) checkRaceFrame(frameOffset uint64) error {
+	if t.WaitForRaceStart {
+		if t.RaceStart == nil {
+			return fmt.Errorf("the race has not started")
+		}
+		if frameOffset < t.RaceStart.FrameOffset {
+			return fmt.Errorf("frame %d is before the race start at frame %d", frameOffset, t.RaceStart.FrameOffset)
+		}
+	}
+	if t.Finished && frameOffset > t.finishFrame {
+		return fmt.Errorf("frame %d is after the race finish at frame %d", frameOffset, t.finishFrame)
+	}
+	return nil
+}
+
+func (t *Timer) removeDetection(detection *Detection) {
+	for i, d := range t.DetectionsInOrder {
+		if d == detection {
+			t.DetectionsInOrder = append(t.DetectionsInOrder[:i], t.DetectionsInOrder[i+1:]...)
+			return
+		}
+	}
+}
+
+func (t *Timer) audit(action string, detection *Detection, fromFrame uint64, toFrame uint64, author string, reason string) {
+	t.Corrections = append(t.Corrections, &Correction{
+		Time:        time.Now(),
+		Author:      author,
+		Action:      action,
+		DetectionID: detection.ID,
+		Gate:        detection.Gate.Name,
+		FromFrame:   fromFrame,
+		ToFrame:     toFrame,
+		Reason:      reason,
+	})
+	t.Logger.Info("detection corrected",
+		"action", action,
+		"id", detection.ID,
+		"gate", detection.Gate.Name,
+		"fromFrame", fromFrame,
+		"toFrame", toFrame,
+		"author", author,
+		"reason", reason)
+}
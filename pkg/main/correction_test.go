@@ -0,0 +1,146 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCorrections(t *testing.T) {
+	tests := []struct {
+		name       string
+		correct    func(timer *Timer) error
+		laps       []int
+		statuses   []LapStatus
+		correction Correction
+	}{
+		{
+			"insert the missed gate",
+			func(timer *Timer) error {
+				_, err := timer.InsertDetection("a", 2400, "director", "missed by the detector")
+				return err
+			},
+			[]int{1000, 800, 1200, 900, 800},
+			[]LapStatus{LapValid, LapValid, LapValid, LapValid, LapValid},
+			Correction{Author: "director", Action: CorrectionInsert, DetectionID: 11, Gate: "a", ToFrame: 2400, Reason: "missed by the detector"},
+		},
+		{
+			"remove a start gate pass",
+			func(timer *Timer) error {
+				return timer.RemoveDetection(8, "director", "false detection")
+			},
+			// the laps on either side of the pass are one, with the a gate passed twice
+			[]int{1000, 800, 1200, 1700},
+			[]LapStatus{LapValid, LapValid, LapInvalid, LapInvalid},
+			Correction{Author: "director", Action: CorrectionRemove, DetectionID: 8, Gate: "start", FromFrame: 3900, Reason: "false detection"},
+		},
+		{
+			"move a start gate pass",
+			func(timer *Timer) error {
+				return timer.MoveDetection(5, 1900, "director", "late detection")
+			},
+			[]int{1000, 900, 1100, 900, 800},
+			[]LapStatus{LapValid, LapValid, LapInvalid, LapValid, LapValid},
+			Correction{Author: "director", Action: CorrectionMove, DetectionID: 5, Gate: "start", FromFrame: 1800, ToFrame: 1900, Reason: "late detection"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			timer := testLapsTimer()
+			ids := map[*Detection]int{}
+			for _, detection := range timer.DetectionsInOrder {
+				ids[detection] = detection.ID
+			}
+
+			if err := test.correct(timer); err != nil {
+				t.Fatal(err)
+			}
+
+			var laps []int
+			var statuses []LapStatus
+			for _, lap := range timer.Laps {
+				laps = append(laps, lap.Frames())
+				statuses = append(statuses, lap.Status)
+			}
+			if !reflect.DeepEqual(laps, test.laps) || !reflect.DeepEqual(statuses, test.statuses) {
+				t.Errorf("got laps %v %v, want %v %v", laps, statuses, test.laps, test.statuses)
+			}
+
+			// the other detections keep their ids
+			for _, detection := range timer.DetectionsInOrder {
+				if id, ok := ids[detection]; ok && id != detection.ID {
+					t.Errorf("got detection id %d, want %d", detection.ID, id)
+				}
+			}
+
+			if len(timer.Corrections) != 1 {
+				t.Fatalf("got %d corrections, want 1", len(timer.Corrections))
+			}
+			correction := *timer.Corrections[0]
+			if correction.Time.IsZero() {
+				t.Errorf("got no correction time")
+			}
+			correction.Time = test.correction.Time
+			if correction != test.correction {
+				t.Errorf("got correction %+v, want %+v", correction, test.correction)
+			}
+		})
+	}
+}
+
+func TestCorrectionErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		correct func(timer *Timer) error
+	}{
+		{"insert for an unknown gate", func(timer *Timer) error {
+			_, err := timer.InsertDetection("c", 2400, "director", "")
+			return err
+		}},
+		{"remove an unknown detection", func(timer *Timer) error {
+			return timer.RemoveDetection(99, "director", "")
+		}},
+		{"move an unknown detection", func(timer *Timer) error {
+			return timer.MoveDetection(99, 2400, "director", "")
+		}},
+		{"insert after the finish", func(timer *Timer) error {
+			timer.FinishRace(5000)
+			_, err := timer.InsertDetection("a", 5100, "director", "")
+			return err
+		}},
+		{"move after the finish", func(timer *Timer) error {
+			timer.FinishRace(5000)
+			return timer.MoveDetection(10, 5100, "director", "")
+		}},
+		{"insert while waiting for the race start", func(timer *Timer) error {
+			timer.WaitForRaceStart = true
+			timer.RaceStart = nil
+			_, err := timer.InsertDetection("a", 100, "director", "")
+			return err
+		}},
+		{"insert before the race start", func(timer *Timer) error {
+			timer.WaitForRaceStart = true
+			timer.RaceStart = &RaceStart{FrameOffset: 300, Source: RaceStartManual}
+			_, err := timer.InsertDetection("a", 100, "director", "")
+			return err
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			timer := testLapsTimer()
+			detections := len(timer.DetectionsInOrder)
+
+			if err := test.correct(timer); err == nil {
+				t.Errorf("got no error")
+			}
+			if len(timer.DetectionsInOrder) != detections || len(timer.Corrections) != 0 {
+				t.Errorf("got %d detections and %d corrections, want them unchanged", len(timer.DetectionsInOrder), len(timer.Corrections))
+			}
+		})
+	}
+}
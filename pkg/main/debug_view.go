@@ -0,0 +1,290 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"fmt"
+	"gocv.io/x/gocv"
+	"image"
+	"image/color"
+)
+
+const (
+	DebugStateIdle     = "idle"
+	DebugStateActive   = "active"
+	DebugStateDetected = "detected"
+	DebugStateRejected = "rejected"
+)
+
+const (
+	// the frame is shown at twice the detector size
+	debugFrameScale     = 2
+	debugPlotRowHeight  = 90
+	debugStateHeight    = 6
+	debugMinBlobPixels  = 4
+	debugThresholdRatio = 0.6
+)
+
+var debugStateColors = map[string]color.RGBA{
+	DebugStateIdle:     {R: 40, G: 40, B: 46},
+	DebugStateActive:   {R: 255, G: 200, B: 0},
+	DebugStateDetected: {R: 26, G: 200, B: 80},
+	DebugStateRejected: {R: 230, G: 40, B: 40},
+}
+
+// DebugView composes the frame with the blobs found in it, the mask of each gate, and a scrolling plot of each gate's
+// area signal, activation value and state, to tune the gate colors and detection thresholds by eye
+type DebugView struct {
+	window     *gocv.Window
+	gates      []*Gate
+	gateColors map[*Gate]color.RGBA
+
+	_canvas    gocv.Mat
+	_frame     gocv.Mat
+	_mask      gocv.Mat
+	_labels    gocv.Mat
+	_stats     gocv.Mat
+	_centroids gocv.Mat
+	_tints     map[*Gate]gocv.Mat
+	_tiles     map[*Gate]gocv.Mat
+
+	_frameRect image.Rectangle
+	_maskRects map[*Gate]image.Rectangle
+	_plotRects map[*Gate]image.Rectangle
+
+	// history of the last seconds, oldest first
+	_areas  map[*Gate][]int
+	_values map[*Gate][]float64
+	_states map[*Gate][]string
+}
+
+// NewDebugView lays out the view for frames of the detector size (width x height)
+func NewDebugView(window *gocv.Window, width int, height int, framesPerSec int, seconds int, gates []*Gate, gateColors []color.RGBA) *DebugView {
+	frameRect := image.Rect(0, 0, width*debugFrameScale, height*debugFrameScale)
+
+	// the masks are in columns of two, next to the frame
+	columns := (len(gates) + 1) / 2
+	if columns < 1 {
+		columns = 1
+	}
+	canvasWidth := frameRect.Dx() + columns*width
+	canvasHeight := frameRect.Dy() + len(gates)*debugPlotRowHeight
+
+	v := &DebugView{
+		window:     window,
+		gates:      gates,
+		gateColors: map[*Gate]color.RGBA{},
+
+		_canvas:    gocv.NewMatWithSize(canvasHeight, canvasWidth, gocv.MatTypeCV8UC3),
+		_frame:     gocv.NewMat(),
+		_mask:      gocv.NewMat(),
+		_labels:    gocv.NewMat(),
+		_stats:     gocv.NewMat(),
+		_centroids: gocv.NewMat(),
+		_tints:     map[*Gate]gocv.Mat{},
+		_tiles:     map[*Gate]gocv.Mat{},
+
+		_frameRect: frameRect,
+		_maskRects: map[*Gate]image.Rectangle{},
+		_plotRects: map[*Gate]image.Rectangle{},
+
+		_areas:  map[*Gate][]int{},
+		_values: map[*Gate][]float64{},
+		_states: map[*Gate][]string{},
+	}
+
+	historyFrames := seconds * framesPerSec
+	for i, gate := range gates {
+		gateColor := gateColors[i]
+		v.gateColors[gate] = gateColor
+		v._tints[gate] = gocv.NewMatWithSizeFromScalar(gocv.NewScalar(float64(gateColor.B), float64(gateColor.G), float64(gateColor.R), 0), height, width, gocv.MatTypeCV8UC3)
+		v._tiles[gate] = gocv.NewMatWithSize(height, width, gocv.MatTypeCV8UC3)
+
+		maskMin := image.Pt(frameRect.Max.X+(i/2)*width, (i%2)*height)
+		v._maskRects[gate] = image.Rectangle{Min: maskMin, Max: maskMin.Add(image.Pt(width, height))}
+		v._plotRects[gate] = image.Rect(0, frameRect.Max.Y+i*debugPlotRowHeight, canvasWidth, frameRect.Max.Y+(i+1)*debugPlotRowHeight)
+
+		v._areas[gate] = make([]int, historyFrames)
+		v._values[gate] = make([]float64, historyFrames)
+		v._states[gate] = make([]string, historyFrames)
+		for j := range v._states[gate] {
+			v._states[gate][j] = DebugStateIdle
+		}
+	}
+
+	return v
+}
+
+// Update draws the view for the frame the detector just processed, and shows it.
+// The detection is the one returned by the detector, before the timer accepted or rejected it.
+func (v *DebugView) Update(img *gocv.Mat, detector *Detector, detection *Detection) {
+	v._canvas.SetTo(gocv.NewScalar(0, 0, 0, 0))
+
+	gocv.Resize(*img, &v._frame, v._frameRect.Size(), 0, 0, gocv.InterpolationNearestNeighbor)
+	region := v._canvas.Region(v._frameRect)
+	v._frame.CopyTo(&region)
+	_ = region.Close()
+
+	trackedGate, progress := detector.Progress()
+	rejectedGate, rejection := detector.Rejection()
+
+	for _, gate := range v.gates {
+		detector.GateMask(gate, &v._mask)
+		v.drawMask(gate)
+		v.drawBlobs(gate)
+
+		state := DebugStateIdle
+		value := 0.0
+		if gate == trackedGate {
+			value = progress.Value
+			if progress.Frames > 0 {
+				state = DebugStateActive
+			}
+		}
+		if detection != nil && detection.Gate == gate {
+			state = DebugStateDetected
+			value = detection.Activation.Value
+		} else if gate == rejectedGate {
+			state = DebugStateRejected
+		}
+
+		v._areas[gate] = append(v._areas[gate][1:], detector.GateArea(gate))
+		v._values[gate] = append(v._values[gate][1:], value)
+		v._states[gate] = append(v._states[gate][1:], state)
+
+		label := fmt.Sprintf("%s  area %d  value %.0f/%.0f  %s", gate.Name, detector.GateArea(gate), value, gate.minActivationValue, state)
+		if gate == trackedGate {
+			label = fmt.Sprintf("%s  frames %d/%d  inactive %d/%d", label, progress.Frames, gate.minActivationFrames, progress.InactivationFrames, gate.minInactivationFrames)
+		}
+		if gate == rejectedGate {
+			label = fmt.Sprintf("%s (%s)", label, rejection)
+		}
+		v.drawPlot(gate, label)
+	}
+
+	v.window.IMShow(v._canvas)
+}
+
+// drawMask puts the gate's mask, tinted in the gate color, next to the frame
+func (v *DebugView) drawMask(gate *Gate) {
+	rect := v._maskRects[gate]
+
+	tile := v._tiles[gate]
+	tile.SetTo(gocv.NewScalar(0, 0, 0, 0))
+	tint := v._tints[gate]
+	tint.CopyToWithMask(&tile, v._mask)
+
+	region := v._canvas.Region(rect)
+	tile.CopyTo(&region)
+	_ = region.Close()
+
+	gocv.Rectangle(&v._canvas, rect, color.RGBA{R: 90, G: 90, B: 100}, 1)
+	gocv.PutText(&v._canvas, gate.Name, rect.Min.Add(image.Pt(5, 15)), gocv.FontHersheyPlain, 1, color.RGBA{R: 255, G: 255, B: 255}, 1)
+}
+
+// drawBlobs outlines each blob of the gate's mask on the frame and on the mask, with a dot at its centroid
+func (v *DebugView) drawBlobs(gate *Gate) {
+	gateColor := v.gateColors[gate]
+	maskRect := v._maskRects[gate]
+
+	count := gocv.ConnectedComponentsWithStats(v._mask, &v._labels, &v._stats, &v._centroids)
+	// label 0 is the background
+	for label := 1; label < count; label++ {
+		pixels := int(v._stats.GetIntAt(label, int(gocv.CC_STAT_AREA)))
+		if pixels < debugMinBlobPixels {
+			continue
+		}
+
+		bounds := image.Rect(
+			int(v._stats.GetIntAt(label, int(gocv.CC_STAT_LEFT))),
+			int(v._stats.GetIntAt(label, int(gocv.CC_STAT_TOP))),
+			int(v._stats.GetIntAt(label, int(gocv.CC_STAT_LEFT))+v._stats.GetIntAt(label, int(gocv.CC_STAT_WIDTH))),
+			int(v._stats.GetIntAt(label, int(gocv.CC_STAT_TOP))+v._stats.GetIntAt(label, int(gocv.CC_STAT_HEIGHT))))
+		centroid := image.Pt(int(v._centroids.GetDoubleAt(label, 0)), int(v._centroids.GetDoubleAt(label, 1)))
+
+		frameBounds := image.Rectangle{Min: bounds.Min.Mul(debugFrameScale), Max: bounds.Max.Mul(debugFrameScale)}
+		gocv.Rectangle(&v._canvas, frameBounds.Add(v._frameRect.Min), gateColor, 1)
+		gocv.Circle(&v._canvas, centroid.Mul(debugFrameScale).Add(v._frameRect.Min), 3, gateColor, -1)
+		gocv.PutText(&v._canvas, fmt.Sprintf("%d", pixels), frameBounds.Min.Add(v._frameRect.Min).Add(image.Pt(0, -3)), gocv.FontHersheyPlain, 0.8, gateColor, 1)
+
+		gocv.Rectangle(&v._canvas, bounds.Add(maskRect.Min), color.RGBA{R: 255, G: 255, B: 255}, 1)
+		gocv.Circle(&v._canvas, centroid.Add(maskRect.Min), 2, color.RGBA{R: 255, G: 255, B: 255}, -1)
+	}
+}
+
+// drawPlot draws the gate's row of the plot: the area signal in the gate color, the activation value in white with
+// the minimum activation value as a dashed line, and the detection state as a strip at the bottom
+func (v *DebugView) drawPlot(gate *Gate, label string) {
+	rect := v._plotRects[gate]
+	areas := v._areas[gate]
+	values := v._values[gate]
+	states := v._states[gate]
+	gateColor := v.gateColors[gate]
+	white := color.RGBA{R: 255, G: 255, B: 255}
+
+	gocv.Rectangle(&v._canvas, rect, color.RGBA{R: 22, G: 22, B: 26}, -1)
+
+	top := rect.Min.Y + 20
+	bottom := rect.Max.Y - debugStateHeight - 2
+	height := bottom - top
+	x := func(i int) int {
+		return rect.Min.X + i*rect.Dx()/len(areas)
+	}
+
+	maxArea := 1
+	for _, area := range areas {
+		if area > maxArea {
+			maxArea = area
+		}
+	}
+
+	// the activation value is scaled so that the threshold is at a fixed height
+	maxValue := gate.minActivationValue / debugThresholdRatio
+	if maxValue <= 0 {
+		maxValue = 1
+	}
+	valueY := func(value float64) int {
+		if value > maxValue {
+			value = maxValue
+		}
+		return bottom - int(value*float64(height)/maxValue)
+	}
+
+	thresholdY := valueY(gate.minActivationValue)
+	for dashX := rect.Min.X; dashX < rect.Max.X; dashX += 12 {
+		gocv.Line(&v._canvas, image.Pt(dashX, thresholdY), image.Pt(dashX+6, thresholdY), color.RGBA{R: 150, G: 150, B: 160}, 1)
+	}
+
+	for i := 1; i < len(areas); i++ {
+		gocv.Line(&v._canvas,
+			image.Pt(x(i-1), bottom-areas[i-1]*height/maxArea),
+			image.Pt(x(i), bottom-areas[i]*height/maxArea),
+			gateColor, 1)
+		gocv.Line(&v._canvas, image.Pt(x(i-1), valueY(values[i-1])), image.Pt(x(i), valueY(values[i])), white, 1)
+	}
+
+	for i, state := range states {
+		stateRect := image.Rect(x(i), rect.Max.Y-debugStateHeight, x(i+1), rect.Max.Y)
+		gocv.Rectangle(&v._canvas, stateRect, debugStateColors[state], -1)
+	}
+
+	gocv.PutText(&v._canvas, label, image.Pt(rect.Min.X+5, rect.Min.Y+14), gocv.FontHersheyPlain, 1, white, 1)
+	gocv.PutText(&v._canvas, fmt.Sprintf("max area %d", maxArea), image.Pt(rect.Max.X-130, rect.Min.Y+14), gocv.FontHersheyPlain, 1, gateColor, 1)
+}
+
+func (v *DebugView) Close() {
+	_ = v._canvas.Close()
+	_ = v._frame.Close()
+	_ = v._mask.Close()
+	_ = v._labels.Close()
+	_ = v._stats.Close()
+	_ = v._centroids.Close()
+	for _, tint := range v._tints {
+		_ = tint.Close()
+	}
+	for _, tile := range v._tiles {
+		_ = tile.Close()
+	}
+}
@@ -6,15 +6,37 @@ package main
 
 import "fmt"
 
+const (
+	DetectionOriginDetector = "detector"
+	DetectionOriginInferred = "inferred"
+	DetectionOriginManual   = "manual"
+)
+
 type Detection struct {
+	ID          int
 	Gate        *Gate
 	FrameOffset uint64
+	Origin      string
+	// the gate activation the detection comes from, only for detector detections
+	Activation *Activation
 }
 
 func (d *Detection) Diff(detection *Detection) int64 {
 	return int64(d.FrameOffset - detection.FrameOffset)
 }
 
+// PeakFrame is the frame where the gate marker was the largest, i.e. the closest to the drone
+func (d *Detection) PeakFrame() uint64 {
+	if d.Activation == nil || uint64(d.Activation.FramesSincePeak) > d.FrameOffset {
+		return d.FrameOffset
+	}
+	return d.FrameOffset - uint64(d.Activation.FramesSincePeak)
+}
+
+func (d *Detection) IsInferred() bool {
+	return d.Origin == DetectionOriginInferred
+}
+
 func (d *Detection) String() string {
-	return fmt.Sprintf("gate: %s, frame: %v", d.Gate.Name, d.FrameOffset)
+	return fmt.Sprintf("id: %d, gate: %s, frame: %v, origin: %s", d.ID, d.Gate.Name, d.FrameOffset, d.Origin)
 }
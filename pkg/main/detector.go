@@ -8,16 +8,22 @@ import (
 	"gocv.io/x/gocv"
 	"image"
 	"image/color"
+	"log/slog"
 )
 
 type Detector struct {
 	gates []*Gate
 
+	Logger *slog.Logger
+
 	_millisPerFrame int
 
 	_buff         *StreamBuffer
 	_lastSeenGate *Gate
 
+	_rejectedGate *Gate
+	_rejection    string
+
 	_frameCount uint64
 
 	_markersMask   gocv.Mat
@@ -28,6 +34,7 @@ type Detector struct {
 	_nonZeroPixels gocv.Mat
 
 	_pixelsByGate map[*Gate]int
+	_areaByGate   map[*Gate]int
 
 	_leftPropPoly  gocv.PointsVector
 	_rightPropPoly gocv.PointsVector
@@ -43,6 +50,7 @@ func NewDetector(img gocv.Mat,
 	detectionWindowInFrames := detectionWindowInMillis / 1000 * framesPerSec
 
 	detector := Detector{
+		Logger:          discardLogger,
 		_millisPerFrame: 1000 / framesPerSec,
 		_buff:           NewStreamBuffer(detectionWindowInFrames),
 		_frameCount:     0,
@@ -57,6 +65,7 @@ func NewDetector(img gocv.Mat,
 		_nonZeroPixels: gocv.NewMat(),
 
 		_pixelsByGate: map[*Gate]int{},
+		_areaByGate:   map[*Gate]int{},
 
 		_leftPropPoly:  gocv.NewPointsVectorFromPoints([][]image.Point{{image.Pt(0, img.Rows()), image.Pt(0, img.Rows()-propHeight), image.Pt(propWidth, img.Rows())}}),
 		_rightPropPoly: gocv.NewPointsVectorFromPoints([][]image.Point{{image.Pt(img.Cols(), img.Rows()), image.Pt(img.Cols(), img.Rows()-propHeight), image.Pt(img.Cols()-propWidth, img.Rows())}}),
@@ -70,37 +79,10 @@ func NewDetector(img gocv.Mat,
 //goland:noinspection GoUnusedParameter
 func (t *Detector) Detect(img *gocv.Mat, window *gocv.Window) *Detection {
 	t._frameCount += 1
+	t._rejectedGate = nil
+	t._rejection = ""
 
-	// convert the image to HSV format so that we can easily isolate the markers by color ranges (mainly Hue)
-	frame := *img
-	gocv.CvtColor(frame, &t._hsvImg, gocv.ColorBGRToHSV)
-
-	t._hsvImg.CopyTo(&t._img)
-
-	for i := 0; i < len(t.gates); i++ {
-		gocv.InRange(t._img, t.gates[i]._markerLowerBoundHSV, t.gates[i]._markerUpperBoundHSV, &t.gates[i]._markerMask)
-		gocv.Merge([]gocv.Mat{t.gates[i]._markerMask, t.gates[i]._markerMask, t.gates[i]._markerMask}, &t.gates[i]._markerMask)
-
-		if i == 0 {
-			gocv.BitwiseOr(t.gates[i]._markerMask, t.gates[i]._markerMask, &t._markersMask)
-		} else {
-			gocv.BitwiseOr(t.gates[i]._markerMask, t._markersMask, &t._markersMask)
-		}
-	}
-	gocv.BitwiseAnd(t._img, t._markersMask, &t._img)
-
-	// convert the color-isolated image to grayscale, and apply threshold so that we can end up with binary image
-	gocv.CvtColor(t._img, &t._grayImg, gocv.ColorBGRToGray)
-	gocv.Threshold(t._grayImg, &t._binaryImg, 100, 255, gocv.ThresholdBinary)
-
-	// draw black triangles on the bottom left, and bottom right of the image to hide the props (if they are in view)
-	// this is necessary because some props have same color as that of markers
-	gocv.FillPoly(&t._binaryImg, t._leftPropPoly, color.RGBA{})
-	gocv.FillPoly(&t._binaryImg, t._rightPropPoly, color.RGBA{})
-
-	//erode and dilate the binary image to remove any last bits of noise
-	gocv.Erode(t._binaryImg, &t._binaryImg, t._kernel)
-	gocv.Dilate(t._binaryImg, &t._binaryImg, t._kernel)
+	t.threshold(img)
 
 	window.IMShow(t._binaryImg)
 
@@ -119,6 +101,9 @@ func (t *Detector) Detect(img *gocv.Mat, window *gocv.Window) *Detection {
 
 	gocv.FindNonZero(t._binaryImg, &t._nonZeroPixels)
 	totalArea := t._nonZeroPixels.Total()
+	for _, gate := range t.gates {
+		t._areaByGate[gate] = 0
+	}
 	if totalArea > 0 {
 		// initialize histogram to zeroes
 		for i := 0; i < len(t.gates); i++ {
@@ -139,6 +124,8 @@ func (t *Detector) Detect(img *gocv.Mat, window *gocv.Window) *Detection {
 
 		largestPixelCount := 0
 		for gate, pixelCount := range t._pixelsByGate {
+			// every 10th pixel was sampled
+			t._areaByGate[gate] = pixelCount * 10
 			if pixelCount > largestPixelCount {
 				largestPixelCount = pixelCount
 				t._lastSeenGate = gate
@@ -154,13 +141,21 @@ func (t *Detector) Detect(img *gocv.Mat, window *gocv.Window) *Detection {
 	activation := t._buff.Push(float64(totalArea), t._lastSeenGate.minActivationValue, t._lastSeenGate.minActivationFrames, t._lastSeenGate.minInactivationFrames)
 	if activation == nil {
 		//no peak
+		if rejection := t._buff.Rejection(); rejection != "" {
+			t._rejectedGate = t._lastSeenGate
+			t._rejection = rejection
+			t.Logger.Debug("gate activation rejected", "gate", t._lastSeenGate.Name, "frame", t._frameCount, "reason", rejection)
+		}
 		return nil
 	}
 
 	//peak detected
+	t.Logger.Debug("gate activation", "gate", t._lastSeenGate.Name, "frame", t._frameCount, "value", int(activation.Value), "frames", activation.Frames)
 	detection := Detection{
 		Gate:        t._lastSeenGate,
 		FrameOffset: t._frameCount,
+		Origin:      DetectionOriginDetector,
+		Activation:  activation,
 	}
 
 	if t._lastSeenGate.lastDetection == nil {
@@ -171,12 +166,82 @@ func (t *Detector) Detect(img *gocv.Mat, window *gocv.Window) *Detection {
 	millisSinceLastDetection := detection.Diff(t._lastSeenGate.lastDetection) * int64(t._millisPerFrame)
 	if int(millisSinceLastDetection) < detection.Gate.minMillisBetweenActivations {
 		// ignore detection
+		t._rejectedGate = t._lastSeenGate
+		t._rejection = RejectedTooSoon
+		t.Logger.Debug("gate activation rejected", "gate", t._lastSeenGate.Name, "frame", t._frameCount, "reason", RejectedTooSoon)
 		return nil
 	}
 
 	return &detection
 }
 
+// threshold isolates the gate markers of the image into the binary image
+func (t *Detector) threshold(img *gocv.Mat) {
+	// convert the image to HSV format so that we can easily isolate the markers by color ranges (mainly Hue)
+	frame := *img
+	gocv.CvtColor(frame, &t._hsvImg, gocv.ColorBGRToHSV)
+
+	t._hsvImg.CopyTo(&t._img)
+
+	for i := 0; i < len(t.gates); i++ {
+		gocv.InRange(t._img, t.gates[i]._markerLowerBoundHSV, t.gates[i]._markerUpperBoundHSV, &t.gates[i]._markerMask)
+		gocv.Merge([]gocv.Mat{t.gates[i]._markerMask, t.gates[i]._markerMask, t.gates[i]._markerMask}, &t.gates[i]._markerMask)
+
+		if i == 0 {
+			gocv.BitwiseOr(t.gates[i]._markerMask, t.gates[i]._markerMask, &t._markersMask)
+		} else {
+			gocv.BitwiseOr(t.gates[i]._markerMask, t._markersMask, &t._markersMask)
+		}
+	}
+	gocv.BitwiseAnd(t._img, t._markersMask, &t._img)
+
+	// convert the color-isolated image to grayscale, and apply threshold so that we can end up with binary image
+	gocv.CvtColor(t._img, &t._grayImg, gocv.ColorBGRToGray)
+	gocv.Threshold(t._grayImg, &t._binaryImg, 100, 255, gocv.ThresholdBinary)
+
+	// draw black triangles on the bottom left, and bottom right of the image to hide the props (if they are in view)
+	// this is necessary because some props have same color as that of markers
+	gocv.FillPoly(&t._binaryImg, t._leftPropPoly, color.RGBA{})
+	gocv.FillPoly(&t._binaryImg, t._rightPropPoly, color.RGBA{})
+
+	//erode and dilate the binary image to remove any last bits of noise
+	gocv.Erode(t._binaryImg, &t._binaryImg, t._kernel)
+	gocv.Dilate(t._binaryImg, &t._binaryImg, t._kernel)
+}
+
+// Mask returns the binary image of the markers in the image, without detecting anything.
+// The returned image is reused by the next call.
+func (t *Detector) Mask(img *gocv.Mat) gocv.Mat {
+	t.threshold(img)
+	return t._binaryImg
+}
+
+// BinaryImage is the combined marker mask of the last frame
+func (t *Detector) BinaryImage() gocv.Mat {
+	return t._binaryImg
+}
+
+// GateMask writes the part of the last frame's binary image that has the color of the gate marker into dst
+func (t *Detector) GateMask(gate *Gate, dst *gocv.Mat) {
+	gocv.CvtColor(gate._markerMask, dst, gocv.ColorBGRToGray)
+	gocv.BitwiseAnd(*dst, t._binaryImg, dst)
+}
+
+// GateArea is the (estimated) number of pixels of the gate's marker in the last frame
+func (t *Detector) GateArea(gate *Gate) int {
+	return t._areaByGate[gate]
+}
+
+// Rejection returns the gate, and the reason, of a peak that was not accepted as a detection in the last frame
+func (t *Detector) Rejection() (*Gate, string) {
+	return t._rejectedGate, t._rejection
+}
+
+// Progress returns the gate the activation is being built up for, and its state after the last frame
+func (t *Detector) Progress() (*Gate, ActivationProgress) {
+	return t._lastSeenGate, t._buff.Progress()
+}
+
 func (t *Detector) AddGate(gate *Gate) {
 	t.gates = append(t.gates, gate)
 }
@@ -184,3 +249,20 @@ func (t *Detector) AddGate(gate *Gate) {
 func (t *Detector) MillisPerFrame() int {
 	return t._millisPerFrame
 }
+
+func (t *Detector) FrameCount() uint64 {
+	return t._frameCount
+}
+
+// SetFrameCount makes the frame offsets continue from the given frame, e.g. when continuing a saved session
+func (t *Detector) SetFrameCount(frameCount uint64) {
+	t._frameCount = frameCount
+}
+
+// Skip makes the frame offsets continue from the given frame after frames of the video were skipped,
+// the activation being built up before the skipped frames is dropped
+func (t *Detector) Skip(frameCount uint64) {
+	t._frameCount = frameCount
+	t._buff.Reset()
+	t._lastSeenGate = nil
+}
@@ -0,0 +1,133 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	EventDetection  = "detection"
+	EventLap        = "lap"
+	EventTransition = "transition"
+	EventRaceStart  = "race_start"
+	EventRaceFinish = "race_finish"
+	EventState      = "state"
+)
+
+// subscriberBufferSize is how many events a subscriber may fall behind before it is dropped
+const subscriberBufferSize = 256
+
+type Event struct {
+	Seq         uint64      `json:"seq"`
+	Type        string      `json:"type"`
+	Time        time.Time   `json:"time"`
+	FrameOffset uint64      `json:"frameOffset"`
+	TimeMillis  int64       `json:"timeMillis"`
+	Data        interface{} `json:"data,omitempty"`
+}
+
+func (e *Event) JSON() ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(e); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n")), nil
+}
+
+// EventBus delivers timer events to subscribers (e.g. the event stream clients),
+// and keeps the most recent events so that new subscribers can catch up
+type EventBus struct {
+	mu          sync.Mutex
+	seq         uint64
+	history     []*Event
+	historySize int
+	subscribers map[chan *Event]bool
+}
+
+func NewEventBus(historySize int) *EventBus {
+	return &EventBus{
+		history:     []*Event{},
+		historySize: historySize,
+		subscribers: map[chan *Event]bool{},
+	}
+}
+
+// Publish never blocks, a subscriber that can't keep up is dropped and its channel is closed
+func (b *EventBus) Publish(event *Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq += 1
+	event.Seq = b.seq
+
+	b.history = append(b.history, event)
+	if len(b.history) > b.historySize {
+		b.history = b.history[len(b.history)-b.historySize:]
+	}
+
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			delete(b.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+// Subscribe returns a channel for new events, and the recent events after the given sequence number
+func (b *EventBus) Subscribe(afterSeq uint64) (chan *Event, []*Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subscriber := make(chan *Event, subscriberBufferSize)
+	b.subscribers[subscriber] = true
+
+	var replay []*Event
+	for _, event := range b.history {
+		if event.Seq > afterSeq {
+			replay = append(replay, event)
+		}
+	}
+
+	return subscriber, replay
+}
+
+func (b *EventBus) Unsubscribe(subscriber chan *Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subscribers[subscriber] {
+		delete(b.subscribers, subscriber)
+		close(subscriber)
+	}
+}
+
+// emit publishes a timer event, if the timer has an event bus.
+// Nothing is published while laps and transitions are being recomputed.
+func (t *Timer) emit(eventType string, frameOffset uint64, data interface{}) {
+	if t.Events == nil || t.rebuilding {
+		return
+	}
+
+	t.Events.Publish(&Event{
+		Type:        eventType,
+		Time:        time.Now(),
+		FrameOffset: frameOffset,
+		TimeMillis:  t.Duration(int(frameOffset)).Milliseconds(),
+		Data:        data,
+	})
+}
+
+// emitState publishes the race state, e.g. after laps were recomputed
+func (t *Timer) emitState() {
+	t.emit(EventState, t.CurrentFrame, NewTimerState(t))
+}
@@ -0,0 +1,155 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// keepAliveInterval keeps idle event stream connections from being closed by proxies
+const keepAliveInterval = 15 * time.Second
+
+var upgrader = websocket.Upgrader{
+	// overlays are usually served from a different origin (e.g. a local file in the streaming software),
+	// the stream is read only so any origin may follow the race
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// lastEventSeq is the sequence number of the last event the client has seen,
+// recent events after it are replayed when the client connects
+func lastEventSeq(r *http.Request) uint64 {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("since")
+	}
+
+	seq, _ := strconv.ParseUint(value, 10, 64)
+	return seq
+}
+
+// ServeServerSentEvents streams the events as Server-Sent Events
+func ServeServerSentEvents(bus *EventBus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		subscriber, replay := bus.Subscribe(lastEventSeq(r))
+		defer bus.Unsubscribe(subscriber)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusOK)
+
+		write := func(event *Event) error {
+			data, err := event.JSON()
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, data)
+			return err
+		}
+
+		for _, event := range replay {
+			if err := write(event); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(keepAliveInterval)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case event, ok := <-subscriber:
+				if !ok {
+					// the client fell behind, it will reconnect and catch up
+					return
+				}
+				if err := write(event); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// ServeWebSocketEvents streams the events as JSON text messages over a WebSocket
+func ServeWebSocketEvents(bus *EventBus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// the upgrader has already replied to the client
+			return
+		}
+		defer conn.Close()
+
+		subscriber, replay := bus.Subscribe(lastEventSeq(r))
+		defer bus.Unsubscribe(subscriber)
+
+		// the stream is one way, but the connection must be read to notice when the client goes away
+		closed := make(chan bool)
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		write := func(event *Event) error {
+			data, err := event.JSON()
+			if err != nil {
+				return err
+			}
+			return conn.WriteMessage(websocket.TextMessage, data)
+		}
+
+		for _, event := range replay {
+			if err := write(event); err != nil {
+				return
+			}
+		}
+
+		keepAlive := time.NewTicker(keepAliveInterval)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case event, ok := <-subscriber:
+				if !ok {
+					return
+				}
+				if err := write(event); err != nil {
+					return
+				}
+			case <-keepAlive.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+					return
+				}
+			case <-closed:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEventBusReplay(t *testing.T) {
+	bus := NewEventBus(3)
+	for i := 0; i < 5; i++ {
+		bus.Publish(&Event{Type: EventDetection})
+	}
+
+	tests := []struct {
+		name     string
+		afterSeq uint64
+		want     []uint64
+	}{
+		// only the last events are kept
+		{"new subscriber", 0, []uint64{3, 4, 5}},
+		{"reconnect", 3, []uint64{4, 5}},
+		{"up to date", 5, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subscriber, replay := bus.Subscribe(test.afterSeq)
+			defer bus.Unsubscribe(subscriber)
+
+			var seqs []uint64
+			for _, event := range replay {
+				seqs = append(seqs, event.Seq)
+			}
+			if !reflect.DeepEqual(seqs, test.want) {
+				t.Errorf("got events %v, want %v", seqs, test.want)
+			}
+		})
+	}
+}
+
+func TestEventBusSlowSubscriber(t *testing.T) {
+	bus := NewEventBus(1)
+	slow, _ := bus.Subscribe(0)
+	live, _ := bus.Subscribe(0)
+
+	for i := 0; i < subscriberBufferSize+1; i++ {
+		bus.Publish(&Event{Type: EventDetection})
+		<-live
+	}
+
+	// the slow subscriber is dropped once its buffer is full, and its channel is closed after the buffered events
+	for i := 0; i < subscriberBufferSize; i++ {
+		<-slow
+	}
+	if _, ok := <-slow; ok {
+		t.Errorf("got an event after the buffer was full, want the channel closed")
+	}
+
+	bus.Publish(&Event{Type: EventDetection})
+	if event := <-live; event.Seq != subscriberBufferSize+2 {
+		t.Errorf("got event %d, want %d", event.Seq, subscriberBufferSize+2)
+	}
+	bus.Unsubscribe(slow)
+}
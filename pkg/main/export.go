@@ -0,0 +1,365 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	ExportCSV         = "csv"
+	ExportJSON        = "json"
+	ExportRotorHazard = "rotorhazard"
+	ExportLiveTime    = "livetime"
+	ExportSRT         = "srt"
+	ExportVTT         = "vtt"
+	ExportFFMetadata  = "ffmetadata"
+	ExportChapters    = "chapters"
+)
+
+var ExportFormats = []string{ExportCSV, ExportJSON, ExportRotorHazard, ExportLiveTime, ExportSRT, ExportVTT, ExportFFMetadata, ExportChapters}
+
+type ExportSplit struct {
+	Sector    string `json:"sector"`
+	StartGate string `json:"startGate"`
+	StopGate  string `json:"stopGate"`
+	Millis    int64  `json:"millis"`
+}
+
+type ExportLap struct {
+	Number      int            `json:"number"`
+	Gate        string         `json:"gate"`
+	Millis      int64          `json:"millis"`
+	Status      string         `json:"status"`
+	Reason      string         `json:"reason,omitempty"`
+	Inferred    bool           `json:"inferred,omitempty"`
+	StartFrame  uint64         `json:"startFrame"`
+	StopFrame   uint64         `json:"stopFrame"`
+	StartMillis int64          `json:"startMillis"`
+	StopMillis  int64          `json:"stopMillis"`
+	Splits      []*ExportSplit `json:"splits"`
+}
+
+type ExportResults struct {
+	Source          string               `json:"source"`
+	Pilot           string               `json:"pilot,omitempty"`
+	RaceStartMillis int64                `json:"raceStartMillis"`
+	HoleshotMillis  int64                `json:"holeshotMillis,omitempty"`
+	FinishMillis    int64                `json:"finishMillis,omitempty"`
+	Laps            []*ExportLap         `json:"laps"`
+	Transitions     []*SessionTransition `json:"transitions"`
+}
+
+func NewExportResults(timer *Timer, source string, pilot string) *ExportResults {
+	results := &ExportResults{
+		Source:      source,
+		Pilot:       pilot,
+		Laps:        []*ExportLap{},
+		Transitions: NewSessionTransitions(timer),
+	}
+
+	if timer.RaceStart != nil {
+		results.RaceStartMillis = timer.Duration(int(timer.RaceStart.FrameOffset)).Milliseconds()
+	}
+
+	if timer.Holeshot != nil {
+		results.HoleshotMillis = timer.Duration(timer.Holeshot.Frames()).Milliseconds()
+	}
+
+	if timer.Finished {
+		results.FinishMillis = timer.Duration(int(timer.finishFrame)).Milliseconds()
+	}
+
+	for i, lap := range timer.Laps {
+		exportLap := &ExportLap{
+			Number:      i + 1,
+			Gate:        lap.Gate().Name,
+			Millis:      timer.Duration(lap.Frames()).Milliseconds(),
+			Status:      string(lap.Status),
+			Reason:      lap.Reason,
+			Inferred:    lap.HasInferredCrossing(),
+			StartFrame:  lap.startFrame,
+			StopFrame:   lap.stopFrame,
+			StartMillis: timer.Duration(int(lap.startFrame)).Milliseconds(),
+			StopMillis:  timer.Duration(int(lap.stopFrame)).Milliseconds(),
+			Splits:      []*ExportSplit{},
+		}
+
+		lapSplits := timer.LapSplits(lap)
+		for j, split := range lapSplits.Splits {
+			exportLap.Splits = append(exportLap.Splits, &ExportSplit{
+				Sector:    lapSplits.Keys[j],
+				StartGate: split.start.Gate.Name,
+				StopGate:  split.stop.Gate.Name,
+				Millis:    timer.Duration(split.Frames()).Milliseconds(),
+			})
+		}
+
+		results.Laps = append(results.Laps, exportLap)
+	}
+
+	return results
+}
+
+// Export writes the results in the format. The subtitles and chapters of a session timed from several videos
+// are those of one of its videos, given by its path. sourceFirstFrame is the frame offset the source video starts at.
+func Export(w io.Writer, timer *Timer, source string, sourceFirstFrame uint64, pilot string, format string, videoPath string) error {
+	results := NewExportResults(timer, source, pilot)
+
+	span := &videoSpan{Path: source}
+	switch format {
+	case ExportSRT, ExportVTT, ExportFFMetadata, ExportChapters:
+		var err error
+		if span, err = exportVideoSpan(timer, source, sourceFirstFrame, videoPath); err != nil {
+			return err
+		}
+	}
+
+	switch format {
+	case ExportCSV:
+		return exportCSV(w, timer, results)
+	case ExportJSON:
+		return exportJSON(w, results)
+	case ExportRotorHazard:
+		return exportRotorHazard(w, results)
+	case ExportLiveTime:
+		return exportLiveTime(w, results)
+	case ExportSRT:
+		return exportSRT(w, results, span)
+	case ExportVTT:
+		return exportVTT(w, results, span)
+	case ExportFFMetadata:
+		return exportFFMetadata(w, results, span)
+	case ExportChapters:
+		return exportChapters(w, results, span)
+	}
+
+	return fmt.Errorf("unknown export format %q", format)
+}
+
+// exportVideoSpan is the part of the session timed from the video, the whole session for a single video
+func exportVideoSpan(timer *Timer, source string, sourceFirstFrame uint64, videoPath string) (*videoSpan, error) {
+	if timer.Playlist == nil {
+		if videoPath != "" && videoPath != source {
+			return nil, fmt.Errorf("the session was not timed from %s", videoPath)
+		}
+		// a continued session is timed from its source after the frames of the session it continued
+		return &videoSpan{Path: source, StartMillis: timer.Duration(int(sourceFirstFrame)).Milliseconds()}, nil
+	}
+
+	var paths []string
+	for i, file := range timer.Playlist.Files {
+		paths = append(paths, file.Path)
+		if file.Path != videoPath {
+			continue
+		}
+
+		span := &videoSpan{
+			Path:        file.Path,
+			StartMillis: timer.Duration(int(file.FirstFrame)).Milliseconds(),
+		}
+		if i+1 < len(timer.Playlist.Files) {
+			span.StopMillis = timer.Duration(int(timer.Playlist.Files[i+1].FirstFrame)).Milliseconds()
+		}
+		return span, nil
+	}
+
+	return nil, fmt.Errorf("the session was timed from several videos, choose one of them with -video %v", paths)
+}
+
+// exportCSV writes one row per lap, with one column per sector of the course
+func exportCSV(w io.Writer, timer *Timer, results *ExportResults) error {
+	sectors := timer.Sectors()
+
+	header := []string{"lap", "gate", "lap_time", "lap_millis", "status", "reason", "inferred", "start_frame", "stop_frame", "start_millis", "stop_millis"}
+	for _, sector := range sectors {
+		header = append(header, sector.Key)
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return fmt.Errorf("could not write CSV. %s", err.Error())
+	}
+
+	for _, lap := range results.Laps {
+		row := []string{
+			strconv.Itoa(lap.Number),
+			lap.Gate,
+			formatLapTime(lap.Millis),
+			strconv.FormatInt(lap.Millis, 10),
+			lap.Status,
+			lap.Reason,
+			strconv.FormatBool(lap.Inferred),
+			strconv.FormatUint(lap.StartFrame, 10),
+			strconv.FormatUint(lap.StopFrame, 10),
+			strconv.FormatInt(lap.StartMillis, 10),
+			strconv.FormatInt(lap.StopMillis, 10),
+		}
+
+		for _, sector := range sectors {
+			value := ""
+			for _, split := range lap.Splits {
+				if split.Sector == sector.Key {
+					value = formatLapTime(split.Millis)
+				}
+			}
+			row = append(row, value)
+		}
+
+		if err := writer.Write(row); err != nil {
+			return fmt.Errorf("could not write CSV. %s", err.Error())
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func exportJSON(w io.Writer, results *ExportResults) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(results); err != nil {
+		return fmt.Errorf("could not write JSON. %s", err.Error())
+	}
+	return nil
+}
+
+type rotorHazardLap struct {
+	LapNumber        int    `json:"lap_number"`
+	LapTimeStamp     int64  `json:"lap_time_stamp"`
+	LapTime          int64  `json:"lap_time"`
+	LapTimeFormatted string `json:"lap_time_formatted"`
+	Source           int    `json:"source"`
+	Deleted          bool   `json:"deleted"`
+	// not a RotorHazard field, the lap was split at an inferred start gate pass
+	Inferred bool `json:"inferred,omitempty"`
+}
+
+type rotorHazardResults struct {
+	Pilot string            `json:"callsign"`
+	Laps  []*rotorHazardLap `json:"laps"`
+}
+
+// exportRotorHazard writes the laps with the field names of RotorHazard's saved race laps.
+// As in RotorHazard, lap 0 is the holeshot, timestamps are relative to the race start, and invalid laps are marked as deleted.
+func exportRotorHazard(w io.Writer, results *ExportResults) error {
+	// RotorHazard lap source of the laps recorded by the timer
+	const rhSourceTimer = 0
+
+	rh := &rotorHazardResults{
+		Pilot: results.Pilot,
+		Laps:  []*rotorHazardLap{},
+	}
+
+	// with the first lap timed from the race start, lap 1 already is the holeshot
+	firstLapFromStart := len(results.Laps) > 0 && results.Laps[0].StartMillis == results.RaceStartMillis
+	if results.HoleshotMillis > 0 && !firstLapFromStart {
+		rh.Laps = append(rh.Laps, &rotorHazardLap{
+			LapNumber:        0,
+			LapTimeStamp:     results.HoleshotMillis,
+			LapTime:          results.HoleshotMillis,
+			LapTimeFormatted: formatLapTime(results.HoleshotMillis),
+			Source:           rhSourceTimer,
+		})
+	}
+
+	for _, lap := range results.Laps {
+		rh.Laps = append(rh.Laps, &rotorHazardLap{
+			LapNumber:        lap.Number,
+			LapTimeStamp:     lap.StopMillis - results.RaceStartMillis,
+			LapTime:          lap.Millis,
+			LapTimeFormatted: formatLapTime(lap.Millis),
+			Source:           rhSourceTimer,
+			Deleted:          lap.Status != string(LapValid),
+			Inferred:         lap.Inferred,
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(rh); err != nil {
+		return fmt.Errorf("could not write JSON. %s", err.Error())
+	}
+	return nil
+}
+
+// exportLiveTime writes the valid laps as a pilot/lap/time CSV, in the layout of LiveTime's lap import
+func exportLiveTime(w io.Writer, results *ExportResults) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"Pilot", "Lap", "Lap Time", "Total Time"}); err != nil {
+		return fmt.Errorf("could not write CSV. %s", err.Error())
+	}
+
+	number := 0
+	for _, lap := range results.Laps {
+		if lap.Status != string(LapValid) {
+			continue
+		}
+		number += 1
+		total := lap.StopMillis - results.RaceStartMillis
+		if err := writer.Write([]string{results.Pilot, strconv.Itoa(number), formatLapTime(lap.Millis), formatLapTime(total)}); err != nil {
+			return fmt.Errorf("could not write CSV. %s", err.Error())
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// formatLapTime formats milliseconds as m:ss.SSS
+func formatLapTime(millis int64) string {
+	d := time.Duration(millis) * time.Millisecond
+	return fmt.Sprintf("%d:%06.3f", int(d.Minutes()), (d % time.Minute).Seconds())
+}
+
+func RunExport(arguments []string) error {
+	var sessionPath string
+	var format string
+	var outputPath string
+	var pilot string
+	var videoPath string
+
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	flags.StringVar(&sessionPath, "session", "", "path to a saved session")
+	flags.StringVar(&format, "format", ExportCSV, fmt.Sprintf("export format, one of %v", ExportFormats))
+	flags.StringVar(&outputPath, "out", "", "path to the export file (default stdout)")
+	flags.StringVar(&pilot, "pilot", "", "pilot name")
+	flags.StringVar(&videoPath, "video", "", "for the subtitles and chapters of a session timed from several videos, the video to export them for")
+	_ = flags.Parse(arguments)
+
+	if sessionPath == "" {
+		return fmt.Errorf("session argument is required")
+	}
+
+	var err error
+	var session *Session
+	if session, err = LoadSession(sessionPath); err != nil {
+		return err
+	}
+
+	var timer *Timer
+	if timer, err = session.NewTimer(); err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if outputPath != "" {
+		if out, err = os.Create(outputPath); err != nil {
+			return fmt.Errorf("could not create export file. %s", err.Error())
+		}
+		defer out.Close()
+	}
+
+	return Export(out, timer, session.Source, session.SourceFirstFrame, pilot, format, videoPath)
+}
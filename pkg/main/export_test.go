@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestExportRotorHazard(t *testing.T) {
+	tests := []struct {
+		name    string
+		results func() *ExportResults
+		want    []rotorHazardLap
+	}{
+		{
+			"holeshot",
+			func() *ExportResults {
+				results := testResults()
+				results.RaceStartMillis = 500
+				results.HoleshotMillis = 1500
+				results.Laps[2].Inferred = true
+				return results
+			},
+			[]rotorHazardLap{
+				{LapNumber: 0, LapTimeStamp: 1500, LapTime: 1500, LapTimeFormatted: "0:01.500"},
+				{LapNumber: 1, LapTimeStamp: 11500, LapTime: 10000, LapTimeFormatted: "0:10.000"},
+				{LapNumber: 2, LapTimeStamp: 16500, LapTime: 5000, LapTimeFormatted: "0:05.000", Deleted: true},
+				// inferred laps are still timer laps
+				{LapNumber: 3, LapTimeStamp: 24500, LapTime: 8000, LapTimeFormatted: "0:08.000", Inferred: true},
+			},
+		},
+		{
+			"first lap from the race start",
+			func() *ExportResults {
+				results := testResults()
+				results.RaceStartMillis = 2000
+				results.HoleshotMillis = 10000
+				return results
+			},
+			// lap 1 is the holeshot, it is not counted twice
+			[]rotorHazardLap{
+				{LapNumber: 1, LapTimeStamp: 10000, LapTime: 10000, LapTimeFormatted: "0:10.000"},
+				{LapNumber: 2, LapTimeStamp: 15000, LapTime: 5000, LapTimeFormatted: "0:05.000", Deleted: true},
+				{LapNumber: 3, LapTimeStamp: 23000, LapTime: 8000, LapTimeFormatted: "0:08.000"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buffer bytes.Buffer
+			if err := exportRotorHazard(&buffer, test.results()); err != nil {
+				t.Fatal(err)
+			}
+
+			var rh struct {
+				Laps []rotorHazardLap `json:"laps"`
+			}
+			if err := json.Unmarshal(buffer.Bytes(), &rh); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rh.Laps, test.want) {
+				t.Errorf("got laps %+v, want %+v", rh.Laps, test.want)
+			}
+		})
+	}
+}
+
+func TestExportVideoSpan(t *testing.T) {
+	timer := testTimer(nil, []string{"start"})
+
+	// a continued session is timed from its source after the frames of the session it continued
+	span, err := exportVideoSpan(timer, "DVR0002.ts", 2000, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (videoSpan{Path: "DVR0002.ts", StartMillis: 20000}); *span != want {
+		t.Errorf("got span %+v, want %+v", *span, want)
+	}
+
+	if _, err = exportVideoSpan(timer, "DVR0002.ts", 2000, "DVR0001.ts"); err == nil {
+		t.Errorf("got no error for a video the session was not timed from")
+	}
+
+	timer.Playlist = testPlaylist()
+	if span, err = exportVideoSpan(timer, "a.ts", 0, "b.ts"); err != nil {
+		t.Fatal(err)
+	}
+	if want := (videoSpan{Path: "b.ts", StartMillis: 10000, StopMillis: 25000}); *span != want {
+		t.Errorf("got span %+v, want %+v", *span, want)
+	}
+}
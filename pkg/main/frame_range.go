@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import "sort"
+
+// FrameRange is a part of the video to process, from its first to its last frame (inclusive)
+type FrameRange struct {
+	First uint64
+	// 0 for the end of the video
+	Last uint64
+}
+
+// NewFrameRanges converts time ranges into frame ranges, sorted by their first frame and with the overlapping ones merged
+func NewFrameRanges(ranges []RangeConfig, framesPerSec int) []*FrameRange {
+	var frameRanges []*FrameRange
+	for _, r := range ranges {
+		frameRange := &FrameRange{
+			First: uint64(r.StartMillis * framesPerSec / 1000),
+		}
+		if r.EndMillis > 0 {
+			frameRange.Last = uint64(r.EndMillis * framesPerSec / 1000)
+		}
+		frameRanges = append(frameRanges, frameRange)
+	}
+
+	sort.SliceStable(frameRanges, func(i, j int) bool {
+		return frameRanges[i].First < frameRanges[j].First
+	})
+
+	var merged []*FrameRange
+	for _, frameRange := range frameRanges {
+		if len(merged) > 0 {
+			previous := merged[len(merged)-1]
+			if previous.Last == 0 || frameRange.First <= previous.Last+1 {
+				if previous.Last != 0 && (frameRange.Last == 0 || frameRange.Last > previous.Last) {
+					previous.Last = frameRange.Last
+				}
+				continue
+			}
+		}
+		merged = append(merged, frameRange)
+	}
+
+	return merged
+}
+
+// Contains is true if the frame is in the range
+func (r *FrameRange) Contains(frame uint64) bool {
+	return frame >= r.First && (r.Last == 0 || frame <= r.Last)
+}
+
+// NextFrameRange returns the range the frame is in, or else the first range after it, nil when there is none
+func NextFrameRange(ranges []*FrameRange, frame uint64) *FrameRange {
+	for _, frameRange := range ranges {
+		if frameRange.Contains(frame) || frameRange.First > frame {
+			return frameRange
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewFrameRanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		ranges []RangeConfig
+		want   []*FrameRange
+	}{
+		{"none", nil, nil},
+		{"single", []RangeConfig{{StartMillis: 1000, EndMillis: 2000}}, []*FrameRange{{100, 200}}},
+		{"sorted", []RangeConfig{{StartMillis: 10000, EndMillis: 20000}, {StartMillis: 0, EndMillis: 5000}}, []*FrameRange{{0, 500}, {1000, 2000}}},
+		{"overlapping", []RangeConfig{{StartMillis: 0, EndMillis: 5000}, {StartMillis: 3000, EndMillis: 8000}}, []*FrameRange{{0, 800}}},
+		{"adjacent", []RangeConfig{{StartMillis: 0, EndMillis: 5000}, {StartMillis: 5010, EndMillis: 6000}}, []*FrameRange{{0, 600}}},
+		{"contained", []RangeConfig{{StartMillis: 0, EndMillis: 10000}, {StartMillis: 2000, EndMillis: 3000}}, []*FrameRange{{0, 1000}}},
+		{"until the end", []RangeConfig{{StartMillis: 1000}, {StartMillis: 5000, EndMillis: 6000}}, []*FrameRange{{100, 0}}},
+		{"extended to the end", []RangeConfig{{StartMillis: 0, EndMillis: 5000}, {StartMillis: 3000}}, []*FrameRange{{0, 0}}},
+		{"separate", []RangeConfig{{StartMillis: 0, EndMillis: 5000}, {StartMillis: 6000}}, []*FrameRange{{0, 500}, {600, 0}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := NewFrameRanges(test.ranges, 100)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", frameRangeValues(got), frameRangeValues(test.want))
+			}
+		})
+	}
+}
+
+func TestNextFrameRange(t *testing.T) {
+	ranges := []*FrameRange{{100, 200}, {500, 0}}
+
+	tests := []struct {
+		frame uint64
+		want  *FrameRange
+	}{
+		{0, ranges[0]},
+		{100, ranges[0]},
+		{200, ranges[0]},
+		{201, ranges[1]},
+		{100000, ranges[1]},
+	}
+
+	for _, test := range tests {
+		if got := NextFrameRange(ranges, test.frame); got != test.want {
+			t.Errorf("frame %d: got %v, want %v", test.frame, got, test.want)
+		}
+	}
+
+	if got := NextFrameRange(ranges[:1], 201); got != nil {
+		t.Errorf("got %v after the last range, want none", got)
+	}
+}
+
+func frameRangeValues(ranges []*FrameRange) []FrameRange {
+	var values []FrameRange
+	for _, r := range ranges {
+		values = append(values, *r)
+	}
+	return values
+}
@@ -0,0 +1,218 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"gocv.io/x/gocv"
+	"html/template"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+//go:embed web/gallery.html
+var galleryPageHTML string
+
+var galleryPageTemplate = template.Must(template.New("gallery").Funcs(template.FuncMap{
+	"lapTime": formatLapTime,
+}).Parse(galleryPageHTML))
+
+// GalleryDetection is a detection listed in the gallery index
+type GalleryDetection struct {
+	*SessionDetection
+	PeakFrame uint64
+	Strip     string
+}
+
+type galleryPageData struct {
+	Source     string
+	Created    time.Time
+	Detections []*GalleryDetection
+}
+
+// NewGalleryDetections lists the detections that can be seen in the video, the caller must hold the timer lock
+func NewGalleryDetections(timer *Timer) []*GalleryDetection {
+	var detections []*GalleryDetection
+	for _, detection := range timer.DetectionsInOrder {
+		if detection.IsInferred() {
+			continue
+		}
+		detections = append(detections, &GalleryDetection{
+			SessionDetection: NewSessionDetection(timer, detection),
+			PeakFrame:        detection.PeakFrame(),
+			Strip:            fmt.Sprintf("detection-%03d-%s.png", detection.ID, detection.Gate.Name),
+		})
+	}
+	return detections
+}
+
+// WriteGalleryStrips saves a thumbnail strip for each detection in the video (frames before, at, and after the peak,
+// with the marker mask overlaid). The frame offsets are relative to firstFrame, the offset of the first frame of the video.
+func WriteGalleryStrips(videoPath string, dir string, detections []*GalleryDetection, detector *Detector,
+	detectorSize image.Point,
+	framesPerSec int,
+	firstFrame uint64,
+	stripFrames int,
+	spacingMillis int,
+	gateColors map[string]color.RGBA) error {
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("could not create gallery directory %s. %s", dir, err.Error())
+	}
+
+	video, err := gocv.OpenVideoCapture(videoPath)
+	if err != nil {
+		return fmt.Errorf("could not open video %s. %s", videoPath, err.Error())
+	}
+	defer video.Close()
+
+	strip := newGalleryStrip(video, detector, detectorSize, firstFrame)
+	defer strip.Close()
+
+	spacing := spacingMillis * framesPerSec / 1000
+	if spacing < 1 {
+		spacing = 1
+	}
+
+	// in video order, so that most seeks are forward
+	sorted := append([]*GalleryDetection{}, detections...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].PeakFrame < sorted[j].PeakFrame
+	})
+
+	for _, detection := range sorted {
+		var offsets []int
+		for i := 0; i < stripFrames; i++ {
+			offsets = append(offsets, (i-stripFrames/2)*spacing)
+		}
+
+		path := filepath.Join(dir, detection.Strip)
+		if err = strip.Write(path, detection.PeakFrame, offsets, framesPerSec, gateColors[detection.Gate]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteGalleryIndex writes the index.html listing the detections of the gallery
+func WriteGalleryIndex(dir string, source string, detections []*GalleryDetection) error {
+	index, err := os.Create(filepath.Join(dir, "index.html"))
+	if err != nil {
+		return fmt.Errorf("could not create gallery index. %s", err.Error())
+	}
+	defer index.Close()
+
+	data := &galleryPageData{
+		Source:     source,
+		Created:    time.Now(),
+		Detections: detections,
+	}
+	if err = galleryPageTemplate.Execute(index, data); err != nil {
+		return fmt.Errorf("could not write gallery index. %s", err.Error())
+	}
+
+	return nil
+}
+
+// galleryStrip reads the frames around a detection and puts them side by side
+type galleryStrip struct {
+	video      *gocv.VideoCapture
+	detector   *Detector
+	size       image.Point
+	firstFrame uint64
+	nextFrame  uint64
+
+	_img   gocv.Mat
+	_layer gocv.Mat
+	_strip gocv.Mat
+}
+
+func newGalleryStrip(video *gocv.VideoCapture, detector *Detector, size image.Point, firstFrame uint64) *galleryStrip {
+	return &galleryStrip{
+		video:      video,
+		detector:   detector,
+		size:       size,
+		firstFrame: firstFrame,
+		_img:       gocv.NewMat(),
+		_layer:     gocv.NewMat(),
+		_strip:     gocv.NewMat(),
+	}
+}
+
+func (s *galleryStrip) Write(path string, peakFrame uint64, offsets []int, framesPerSec int, gateColor color.RGBA) error {
+	s._strip.Close()
+	s._strip = gocv.NewMat()
+
+	for _, offset := range offsets {
+		frame := int64(peakFrame) + int64(offset) - int64(s.firstFrame)
+		thumbnail := s.thumbnail(frame, gateColor)
+		label := "peak"
+		if offset != 0 {
+			label = fmt.Sprintf("%+dms", offset*1000/framesPerSec)
+		}
+		gocv.PutText(&thumbnail, label, image.Pt(5, 15), gocv.FontHersheyPlain, 1, color.RGBA{R: 255, G: 255, B: 255}, 1)
+		if offset == 0 {
+			gocv.Rectangle(&thumbnail, image.Rect(0, 0, thumbnail.Cols(), thumbnail.Rows()), gateColor, 2)
+		}
+
+		if s._strip.Empty() {
+			thumbnail.CopyTo(&s._strip)
+		} else {
+			joined := gocv.NewMat()
+			gocv.Hconcat(s._strip, thumbnail, &joined)
+			s._strip.Close()
+			s._strip = joined
+		}
+		thumbnail.Close()
+	}
+
+	if ok := gocv.IMWrite(path, s._strip); !ok {
+		return fmt.Errorf("could not write gallery image %s", path)
+	}
+	return nil
+}
+
+// thumbnail is the frame at the detector size with the marker mask tinted in the gate color,
+// or a black image if the frame is not in the video
+func (s *galleryStrip) thumbnail(frame int64, gateColor color.RGBA) gocv.Mat {
+	thumbnail := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), s.size.Y, s.size.X, gocv.MatTypeCV8UC3)
+
+	if frame < 0 {
+		return thumbnail
+	}
+
+	if uint64(frame) != s.nextFrame {
+		s.video.Set(gocv.VideoCapturePosFrames, float64(frame))
+	}
+	s.nextFrame = uint64(frame) + 1
+	if ok := s.video.Read(&s._img); !ok || s._img.Empty() {
+		return thumbnail
+	}
+
+	gocv.Resize(s._img, &thumbnail, s.size, 0, 0, gocv.InterpolationArea)
+
+	mask := s.detector.Mask(&thumbnail)
+	tint := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(float64(gateColor.B), float64(gateColor.G), float64(gateColor.R), 0), s.size.Y, s.size.X, gocv.MatTypeCV8UC3)
+	defer tint.Close()
+
+	s._layer.Close()
+	s._layer = gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), s.size.Y, s.size.X, gocv.MatTypeCV8UC3)
+	tint.CopyToWithMask(&s._layer, mask)
+	gocv.AddWeighted(thumbnail, 1, s._layer, 0.6, 0, &thumbnail)
+
+	return thumbnail
+}
+
+func (s *galleryStrip) Close() {
+	_ = s._img.Close()
+	_ = s._layer.Close()
+	_ = s._strip.Close()
+}
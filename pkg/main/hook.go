@@ -0,0 +1,208 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hookQueueSize is how many events may wait for a slow hook before new ones are dropped
+const hookQueueSize = 64
+
+// Hook runs a local command, or POSTs to a URL, whenever a matching timer event is published.
+//
+// Events are delivered one at a time, in order, on the hook's own goroutine, so a slow hook never stalls frame processing.
+type Hook struct {
+	events  map[string]bool
+	command []string
+	url     string
+	timeout time.Duration
+
+	Logger *slog.Logger
+
+	_bus        *EventBus
+	_subscriber chan *Event
+	_queue      chan *Event
+	_client     *http.Client
+	_done       sync.WaitGroup
+}
+
+func NewHook(events []string, command []string, url string, timeoutMillis int) *Hook {
+	hook := &Hook{
+		events:  map[string]bool{},
+		command: command,
+		url:     url,
+		timeout: time.Duration(timeoutMillis) * time.Millisecond,
+		Logger:  discardLogger,
+		_queue:  make(chan *Event, hookQueueSize),
+		_client: &http.Client{Timeout: time.Duration(timeoutMillis) * time.Millisecond},
+	}
+
+	for _, event := range events {
+		hook.events[event] = true
+	}
+
+	return hook
+}
+
+func (h *Hook) String() string {
+	if h.url != "" {
+		return h.url
+	}
+	return strings.Join(h.command, " ")
+}
+
+func (h *Hook) Start(bus *EventBus) {
+	h._bus = bus
+	// no replay, hooks only act on new events
+	h._subscriber, _ = bus.Subscribe(^uint64(0))
+
+	h._done.Add(2)
+
+	go func() {
+		defer h._done.Done()
+		defer close(h._queue)
+
+		for event := range h._subscriber {
+			if len(h.events) > 0 && !h.events[event.Type] {
+				continue
+			}
+
+			select {
+			case h._queue <- event:
+			default:
+				h.Logger.Warn("hook is too slow, event dropped", "hook", h.String(), "event", event.Type, "seq", event.Seq)
+			}
+		}
+	}()
+
+	go func() {
+		defer h._done.Done()
+
+		for event := range h._queue {
+			if err := h.deliver(event); err != nil {
+				h.Logger.Error("hook failed", "hook", h.String(), "event", event.Type, "seq", event.Seq, "reason", err.Error())
+			} else {
+				h.Logger.Debug("hook delivered", "hook", h.String(), "event", event.Type, "seq", event.Seq)
+			}
+		}
+	}()
+}
+
+// Close stops the hook, after the queued events have been delivered
+func (h *Hook) Close() {
+	if h._bus == nil {
+		return
+	}
+	h._bus.Unsubscribe(h._subscriber)
+	h._done.Wait()
+}
+
+func (h *Hook) deliver(event *Event) error {
+	payload, err := event.JSON()
+	if err != nil {
+		return fmt.Errorf("could not serialize event. %s", err.Error())
+	}
+
+	if h.url != "" {
+		return h.post(payload)
+	}
+	return h.run(event, payload)
+}
+
+func (h *Hook) post(payload []byte) error {
+	response, err := h._client.Post(h.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status %s", response.Status)
+	}
+	return nil
+}
+
+// run executes the command with the event JSON on stdin, and the event fields as environment variables
+func (h *Hook) run(event *Event, payload []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, h.command[0], h.command[1:]...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), eventEnv(event)...)
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("timed out after %v", h.timeout)
+		}
+		return err
+	}
+	return nil
+}
+
+var envNameSeparators = regexp.MustCompile(`([a-z0-9])([A-Z])`)
+
+// eventEnv flattens the event into EVENT_* variables, e.g. EVENT_TYPE=lap, EVENT_DATA_MILLIS=10000
+func eventEnv(event *Event) []string {
+	env := []string{
+		"EVENT_TYPE=" + event.Type,
+		"EVENT_SEQ=" + strconv.FormatUint(event.Seq, 10),
+		"EVENT_FRAME_OFFSET=" + strconv.FormatUint(event.FrameOffset, 10),
+		"EVENT_TIME_MILLIS=" + strconv.FormatInt(event.TimeMillis, 10),
+	}
+
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return env
+	}
+
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		// not an object
+		return env
+	}
+
+	var names []string
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var value string
+		switch v := fields[name].(type) {
+		case string:
+			value = v
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			value = strconv.FormatBool(v)
+		default:
+			// nested objects are only available in the JSON on stdin
+			continue
+		}
+
+		envName := strings.ToUpper(envNameSeparators.ReplaceAllString(name, "${1}_${2}"))
+		env = append(env, "EVENT_DATA_"+envName+"="+value)
+	}
+
+	return env
+}
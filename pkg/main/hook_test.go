@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEventEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		data interface{}
+		want []string
+	}{
+		{
+			"detection",
+			&SessionDetection{ID: 3, Gate: "start", FrameOffset: 1000, TimeMillis: 10000, Origin: DetectionOriginDetector,
+				Activation: &Activation{Frames: 12, Value: 5400.5}},
+			[]string{
+				// the nested activation is left out, the fields are in name order
+				"EVENT_DATA_FRAME_OFFSET=1000",
+				"EVENT_DATA_GATE=start",
+				"EVENT_DATA_ID=3",
+				"EVENT_DATA_ORIGIN=" + DetectionOriginDetector,
+				"EVENT_DATA_TIME_MILLIS=10000",
+			},
+		},
+		{
+			"flags and fractions",
+			map[string]interface{}{"valid": true, "ratio": 0.25},
+			[]string{"EVENT_DATA_RATIO=0.25", "EVENT_DATA_VALID=true"},
+		},
+		{"no data", nil, nil},
+		{"not an object", []int{1, 2}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event := &Event{Seq: 7, Type: EventDetection, FrameOffset: 1000, TimeMillis: 10000, Data: test.data}
+
+			want := append([]string{
+				"EVENT_TYPE=detection",
+				"EVENT_SEQ=7",
+				"EVENT_FRAME_OFFSET=1000",
+				"EVENT_TIME_MILLIS=10000",
+			}, test.want...)
+			if env := eventEnv(event); !reflect.DeepEqual(env, want) {
+				t.Errorf("got %v, want %v", env, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import "sort"
+
+// minLapsForInference is the number of valid laps needed before a lap can be considered anomalous
+const minLapsForInference = 2
+
+// missedStartGateFrame checks whether the pass through the start gate was missed during the lap.
+//
+// This is the case when the lap is much longer than the median lap, and the other gates were
+// passed in sequence for two full circuits. The start gate crossing is then estimated by
+// interpolating between the gates before and after it, using the median time of the sectors next to the start gate.
+func (t *Timer) missedStartGateFrame(lap *Lap, passes []*Detection) (uint64, bool) {
+	if t.AnomalyFactor <= 0 || len(t.Track) < 2 {
+		return 0, false
+	}
+
+	var lapFrames []int
+	for _, validLap := range t.ValidLaps() {
+		lapFrames = append(lapFrames, validLap.Frames())
+	}
+	if len(lapFrames) < minLapsForInference {
+		return 0, false
+	}
+
+	if float64(lap.Frames()) < t.AnomalyFactor*median(lapFrames) {
+		return 0, false
+	}
+
+	// two circuits through the other gates, finishing through the start gate
+	expected := append(append(append([]string{}, t.Track[1:]...), t.Track[1:]...), t.Track[0])
+	if len(passes) != len(expected) {
+		return 0, false
+	}
+	for i, pass := range passes {
+		if pass.Gate.Name != expected[i] {
+			return 0, false
+		}
+	}
+
+	before := passes[len(t.Track)-2]
+	after := passes[len(t.Track)-1]
+
+	startGateName := t.Track[0]
+	beforeFrames := t.medianTransitionFrames(before.Gate.Name + "->" + startGateName)
+	afterFrames := t.medianTransitionFrames(startGateName + "->" + after.Gate.Name)
+
+	ratio := 0.5
+	if beforeFrames > 0 && afterFrames > 0 {
+		ratio = beforeFrames / (beforeFrames + afterFrames)
+	}
+
+	return before.FrameOffset + uint64(ratio*float64(after.FrameOffset-before.FrameOffset)), true
+}
+
+func (t *Timer) medianTransitionFrames(name string) float64 {
+	var frames []int
+	for _, transition := range t.Transitions {
+		if transition.Name() == name {
+			frames = append(frames, transition.Frames())
+		}
+	}
+
+	if len(frames) == 0 {
+		return 0
+	}
+	return median(frames)
+}
+
+func median(values []int) float64 {
+	sorted := append([]int{}, values...)
+	sort.Ints(sorted)
+
+	if len(sorted)%2 == 0 {
+		return float64(sorted[len(sorted)/2-1]+sorted[len(sorted)/2]) / 2
+	}
+	return float64(sorted[len(sorted)/2])
+}
@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testInferencePasses are two laps of 1000 frames, with 300 frames from the b gate to the start gate and from it to the a gate
+var testInferencePasses = []testPass{
+	{"start", 0}, {"a", 300}, {"b", 700},
+	{"start", 1000}, {"a", 1300}, {"b", 1700},
+	{"start", 2000},
+}
+
+func TestMissedStartGate(t *testing.T) {
+	tests := []struct {
+		name     string
+		split    bool
+		passes   []testPass
+		inferred []uint64
+		laps     []int
+		statuses []LapStatus
+	}{
+		{
+			"fewer than 2 laps",
+			true,
+			[]testPass{{"start", 0}, {"a", 300}, {"b", 700}, {"start", 1000},
+				{"a", 1300}, {"b", 1700}, {"a", 2300}, {"b", 2700}, {"start", 3000}},
+			nil,
+			[]int{1000, 2000},
+			[]LapStatus{LapValid, LapInvalid},
+		},
+		{
+			"split point",
+			true,
+			append(append([]testPass{}, testInferencePasses...),
+				testPass{"a", 2300}, testPass{"b", 2700}, testPass{"a", 3300}, testPass{"b", 3700}, testPass{"start", 4000}),
+			// halfway between b and a, as the sectors next to the start gate are as long
+			[]uint64{3000},
+			[]int{1000, 1000, 1000, 1000},
+			[]LapStatus{LapValid, LapValid, LapValid, LapValid},
+		},
+		{
+			"not split",
+			false,
+			append(append([]testPass{}, testInferencePasses...),
+				testPass{"a", 2300}, testPass{"b", 2700}, testPass{"a", 3300}, testPass{"b", 3700}, testPass{"start", 4000}),
+			nil,
+			[]int{1000, 1000, 2000},
+			[]LapStatus{LapValid, LapValid, LapInvalid},
+		},
+		{
+			"too short to split",
+			true,
+			append(append([]testPass{}, testInferencePasses...),
+				testPass{"a", 2150}, testPass{"b", 2350}, testPass{"a", 2550}, testPass{"b", 2850}, testPass{"start", 3400}),
+			nil,
+			[]int{1000, 1000, 1400},
+			[]LapStatus{LapValid, LapValid, LapInvalid},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			timer := testTimer(Track{"start", "a", "b"}, []string{"start", "a", "b"})
+			timer.AnomalyFactor = 1.5
+			timer.SplitMissedStartGate = test.split
+			for _, pass := range test.passes {
+				timer.AddDetection(&Detection{Gate: timer.GatesByName[pass.gate], FrameOffset: pass.frame, Origin: DetectionOriginDetector})
+			}
+
+			var inferred []uint64
+			for _, detection := range timer.DetectionsInOrder {
+				if detection.IsInferred() {
+					inferred = append(inferred, detection.FrameOffset)
+				}
+			}
+			var laps []int
+			var statuses []LapStatus
+			for _, lap := range timer.Laps {
+				laps = append(laps, lap.Frames())
+				statuses = append(statuses, lap.Status)
+			}
+
+			if !reflect.DeepEqual(inferred, test.inferred) {
+				t.Errorf("got inferred detections %v, want %v", inferred, test.inferred)
+			}
+			if !reflect.DeepEqual(laps, test.laps) || !reflect.DeepEqual(statuses, test.statuses) {
+				t.Errorf("got laps %v %v, want %v %v", laps, statuses, test.laps, test.statuses)
+			}
+		})
+	}
+}
+
+func TestMissedStartGateEvent(t *testing.T) {
+	timer := testTimer(Track{"start", "a", "b"}, []string{"start", "a", "b"}, testInferencePasses...)
+	timer.AnomalyFactor = 1.5
+	timer.SplitMissedStartGate = true
+	timer.Events = NewEventBus(100)
+	for _, pass := range []testPass{{"a", 2300}, {"b", 2700}, {"a", 3300}, {"b", 3700}, {"start", 4000}} {
+		timer.AddDetection(&Detection{Gate: timer.GatesByName[pass.gate], FrameOffset: pass.frame, Origin: DetectionOriginDetector})
+	}
+
+	_, events := timer.Events.Subscribe(0)
+	for _, event := range events {
+		if detection, ok := event.Data.(*SessionDetection); ok && detection.Origin == DetectionOriginInferred {
+			if detection.Gate != "start" || event.FrameOffset != 3000 {
+				t.Errorf("got inferred detection %+v at frame %d, want the start gate at frame 3000", detection, event.FrameOffset)
+			}
+			return
+		}
+	}
+	t.Errorf("got no detection event for the inferred detection")
+}
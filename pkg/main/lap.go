@@ -4,22 +4,75 @@
 
 package main
 
+type LapStatus string
+
+const (
+	LapValid      LapStatus = "valid"
+	LapInvalid    LapStatus = "invalid"
+	LapIncomplete LapStatus = "incomplete"
+)
+
 type Lap struct {
-	start *Detection
-	stop  *Detection
+	Status LapStatus
+	Reason string
+
+	gate       *Gate
+	start      *Detection // nil when the lap is timed from the race start
+	stop       *Detection // nil when the lap is incomplete
+	startFrame uint64
+	stopFrame  uint64
+
+	// estimated start gate crossing, when the lap is two laps with a missed start gate pass
+	missedStartGateFrame uint64
 }
 
 func NewLap(start *Detection, stop *Detection) *Lap {
 	return &Lap{
-		start: start,
-		stop:  stop,
+		Status:     LapValid,
+		gate:       stop.Gate,
+		start:      start,
+		stop:       stop,
+		startFrame: start.FrameOffset,
+		stopFrame:  stop.FrameOffset,
+	}
+}
+
+func NewLapFromRaceStart(raceStart *RaceStart, stop *Detection) *Lap {
+	return &Lap{
+		Status:     LapValid,
+		gate:       stop.Gate,
+		start:      nil,
+		stop:       stop,
+		startFrame: raceStart.FrameOffset,
+		stopFrame:  stop.FrameOffset,
+	}
+}
+
+// NewIncompleteLap creates a lap that was started, but not finished, before the race was over
+func NewIncompleteLap(gate *Gate, start *Detection, startFrame uint64, stopFrame uint64) *Lap {
+	return &Lap{
+		Status:     LapIncomplete,
+		gate:       gate,
+		start:      start,
+		stop:       nil,
+		startFrame: startFrame,
+		stopFrame:  stopFrame,
 	}
 }
 
 func (l *Lap) Frames() int {
-	return int(l.stop.FrameOffset - l.start.FrameOffset)
+	return int(l.stopFrame - l.startFrame)
 }
 
 func (l *Lap) Gate() *Gate {
-	return l.start.Gate
+	return l.gate
+}
+
+func (l *Lap) IsValid() bool {
+	return l.Status == LapValid
+}
+
+// HasInferredCrossing tells whether the lap starts or stops at an estimated start gate crossing
+func (l *Lap) HasInferredCrossing() bool {
+	return (l.start != nil && l.start.IsInferred()) || (l.stop != nil && l.stop.IsInferred())
 }
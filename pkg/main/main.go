@@ -11,133 +11,806 @@ import (
 	"gocv.io/x/gocv"
 	"image"
 	"image/color"
+	"log/slog"
+	"math"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
-func ProcessArgs() (string, *Config, error) {
+// the frames are resized to the detector size before the gates are detected
+const (
+	detectorWidth  = 240
+	detectorHeight = 180
+)
+
+// videoPathsFlag collects the values of a flag that can be given several times
+type videoPathsFlag []string
+
+func (f *videoPathsFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *videoPathsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+type Args struct {
+	// the first of the VideoPaths
+	VideoPath string
+	// several videos are timed as one session
+	VideoPaths []string
+	ConfigPath string
+	LoadPath   string
+	SavePath   string
+	OutputPath string
+	ClipsDir   string
+	GalleryDir string
+	LogLevel   string
+	Review     bool
+	Debug      bool
+	Start      time.Duration
+	End        time.Duration
+}
+
+func ProcessArgs() (*Args, *Config, *Session, error) {
 	self, _ := os.Executable()
 	self = filepath.Base(self)
 
-	var videoPath string
-	var configPath string
-
-	flag.StringVar(&videoPath, "video", "", "path to mp4, ts, or rtsp stream")
-	flag.StringVar(&configPath, "config", "", "path to config file")
+	args := &Args{}
+
+	var videoPaths videoPathsFlag
+	flag.Var(&videoPaths, "video", "path to mp4, ts, or rtsp stream, repeat it or use a glob (e.g. 'DVR/*.ts') to time several videos as one session")
+	flag.StringVar(&args.ConfigPath, "config", "", "path to config file")
+	flag.StringVar(&args.LoadPath, "load", "", "path to a saved session to review, or to continue timing with -video")
+	flag.StringVar(&args.SavePath, "save", "", "path to save the session to when the video ends")
+	flag.StringVar(&args.OutputPath, "output", "", "path to write an annotated copy of the video to, overrides the config")
+	flag.StringVar(&args.ClipsDir, "clips", "", "directory to cut clips of the laps and detections into, overrides the config")
+	flag.StringVar(&args.GalleryDir, "gallery", "", "directory to save the detection thumbnails and their index page into, overrides the config")
+	flag.StringVar(&args.LogLevel, "log-level", "", "debug, info, warn or error, overrides the config")
+	flag.DurationVar(&args.Start, "start", 0, "time of the video to start processing at, e.g. 1m30s, overrides the config ranges")
+	flag.DurationVar(&args.End, "end", 0, "time of the video to stop processing at, e.g. 5m, overrides the config ranges")
+	flag.BoolVar(&args.Debug, "debug", false, "show the debug view with the gate masks and signals, overrides the config")
+	flag.BoolVar(&args.Review, "review", false, "step through the video of the -load session and correct its detections")
 
 	flag.Parse()
 
-	if videoPath == "" {
+	var err error
+	if args.VideoPaths, err = ExpandVideoPaths(videoPaths); err != nil {
+		return nil, nil, nil, err
+	}
+	if len(args.VideoPaths) > 0 {
+		args.VideoPath = args.VideoPaths[0]
+	}
+
+	if args.VideoPath == "" && args.LoadPath == "" {
 		fmt.Printf("%s: error: video argument is required\n", self)
 		os.Exit(1)
 	}
 
-	if configPath == "" {
+	if args.ConfigPath == "" && args.LoadPath == "" {
 		fmt.Printf("%s: error: config argument is required\n", self)
 		os.Exit(1)
 	}
 
+	if args.Review && args.LoadPath == "" {
+		fmt.Printf("%s: error: load argument is required to review\n", self)
+		os.Exit(1)
+	}
+
+	var session *Session
+	if args.LoadPath != "" {
+		if session, err = LoadSession(args.LoadPath); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	// the config snapshot of a saved session is used, unless a config file is given
 	var config *Config
-	var err error
-	if config, err = NewConfig(configPath); err != nil {
-		return "", nil, err
+	if args.ConfigPath != "" {
+		if config, err = NewConfig(args.ConfigPath); err != nil {
+			return nil, nil, nil, err
+		}
+	} else {
+		config = session.Config
+		// a loaded session is only checked for what its review needs, timing more video needs all of its settings
+		if args.VideoPath != "" && !args.Review {
+			if err = config.Validate(); err != nil {
+				return nil, nil, nil, fmt.Errorf("session config can not be used to continue timing. %s", err.Error())
+			}
+		}
+	}
+
+	if args.LogLevel != "" {
+		config.Log.Level = args.LogLevel
+	}
+
+	if args.OutputPath != "" {
+		config.Output.Video = args.OutputPath
+	}
+
+	if args.ClipsDir != "" {
+		config.Clips.Dir = args.ClipsDir
+	}
+
+	if args.GalleryDir != "" {
+		config.Gallery.Dir = args.GalleryDir
+	}
+
+	if args.Debug {
+		config.Debug.View = true
+	}
+
+	if args.Start != 0 || args.End != 0 {
+		config.Ranges = []RangeConfig{{StartMillis: int(args.Start.Milliseconds()), EndMillis: int(args.End.Milliseconds())}}
+		if err = config.Validate(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	return args, config, session, nil
+}
+
+func NewTimerFromConfig(config *Config, gates []*Gate) *Timer {
+	timer := NewTimer(config.FramesPerSec)
+	timer.WaitForRaceStart = config.Race.Start.Mode != RaceStartGate
+	timer.FirstLapFromStart = config.Race.FirstLapFromStart
+	timer.AnomalyFactor = config.Inference.AnomalyFactor
+	timer.SplitMissedStartGate = config.Inference.SplitMissedStartGate
+	timer.Track = config.Track.Sequence
+	if len(timer.Track) == 0 {
+		// by default, the gates are passed in the order they are listed
+		for _, gateConfig := range config.Gates {
+			timer.Track = append(timer.Track, gateConfig.Name)
+		}
+	}
+	for index, gate := range gates {
+		timer.AddGate(index, gate)
+	}
+	return timer
+}
+
+func NewGatesFromConfig(config *Config, img gocv.Mat) []*Gate {
+	var gates []*Gate
+
+	for _, gateConfig := range config.Gates {
+		gates = append(gates, NewGate(
+			gateConfig.Name,
+			img,
+			GateColor2Scalar(gateConfig.Color.LowerBoundHSV),
+			GateColor2Scalar(gateConfig.Color.UpperBoundHSV),
+			gateConfig.Detection.MinMillisBetweenActivations,
+			gateConfig.Detection.MinActivationValue,
+			gateConfig.Detection.MinActivationFrames,
+			gateConfig.Detection.MinInactivationFrames))
+	}
+
+	return gates
+}
+
+func NewOverlayFromConfig(config *Config) (*Overlay, error) {
+	overlayColor, err := ParseHexColor(config.Overlay.Color)
+	if err != nil {
+		return nil, err
+	}
+	backgroundColor, err := ParseHexColor(config.Overlay.BackgroundColor)
+	if err != nil {
+		return nil, err
+	}
+
+	var elements []*OverlayElement
+	for _, elementConfig := range config.Overlay.Elements {
+		element := &OverlayElement{
+			Content:    elementConfig.Content,
+			X:          elementConfig.X,
+			Y:          elementConfig.Y,
+			Align:      elementConfig.Align,
+			FontScale:  config.Overlay.FontScale,
+			Color:      overlayColor,
+			Background: elementConfig.Background,
+		}
+		if elementConfig.FontScale > 0 {
+			element.FontScale *= elementConfig.FontScale
+		}
+		if elementConfig.Color != "" {
+			if element.Color, err = ParseHexColor(elementConfig.Color); err != nil {
+				return nil, err
+			}
+		}
+		elements = append(elements, element)
+	}
+
+	return NewOverlay(elements, backgroundColor, config.Overlay.BackgroundOpacity), nil
+}
+
+// CreateGallery saves the detection thumbnails and their index page, the caller must not hold the timer lock
+func CreateGallery(timer *Timer, config *Config, videoPath string, firstFrame uint64, detector *Detector, detectorSize image.Point) error {
+	gateColors := map[string]color.RGBA{}
+	for _, gateConfig := range config.Gates {
+		gateColors[gateConfig.Name] = GateColor2RGBA(gateConfig.Color.LowerBoundHSV, gateConfig.Color.UpperBoundHSV)
+	}
+
+	var detections []*GalleryDetection
+	var files []*PlaylistFile
+	timer.withLock(func() {
+		detections = NewGalleryDetections(timer)
+		files = playlistFiles(timer, videoPath, firstFrame)
+	})
+
+	// the strips are saved from the video each detection is in
+	for _, file := range files {
+		var fileDetections []*GalleryDetection
+		for _, detection := range detections {
+			if playlistFileAt(files, detection.PeakFrame) == file {
+				fileDetections = append(fileDetections, detection)
+			}
+		}
+		if len(fileDetections) == 0 {
+			continue
+		}
+
+		if err := WriteGalleryStrips(
+			file.Path,
+			config.Gallery.Dir,
+			fileDetections,
+			detector,
+			detectorSize,
+			config.FramesPerSec,
+			file.FirstFrame,
+			config.Gallery.Frames,
+			config.Gallery.SpacingMillis,
+			gateColors); err != nil {
+			return err
+		}
+	}
+
+	return WriteGalleryIndex(config.Gallery.Dir, videoPath, detections)
+}
+
+// CutClips extracts the clips of the laps and detections from the video, the caller must not hold the timer lock
+func CutClips(timer *Timer, config *Config, videoPath string, firstFrame uint64) error {
+	millisToFrames := func(millis int) int {
+		return millis * config.FramesPerSec / 1000
+	}
+
+	var clips []*Clip
+	var files []*PlaylistFile
+	timer.withLock(func() {
+		clips = timer.Clips(
+			config.Clips.BestLap,
+			config.Clips.Laps,
+			config.Clips.Detections,
+			millisToFrames(config.Clips.LapPaddingMillis),
+			millisToFrames(config.Clips.DetectionPaddingMillis))
+		files = playlistFiles(timer, videoPath, firstFrame)
+	})
+
+	extension := ClipExtension(config.Output.Video, config.Output.Codec)
+
+	// each clip is cut from the video its middle is in
+	for _, file := range files {
+		var fileClips []*Clip
+		for _, clip := range clips {
+			if playlistFileAt(files, (clip.StartFrame+clip.StopFrame)/2) == file {
+				fileClips = append(fileClips, clip)
+			}
+		}
+		if len(fileClips) == 0 {
+			continue
+		}
+
+		if err := ExtractClips(file.Path, config.Clips.Dir, config.Output.Codec, extension, config.FramesPerSec, file.FirstFrame, fileClips); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// playlistFiles are the videos of the timer's playlist, or else the single video, the caller must hold the timer lock
+func playlistFiles(timer *Timer, videoPath string, firstFrame uint64) []*PlaylistFile {
+	if timer.Playlist == nil {
+		return []*PlaylistFile{{Path: videoPath, FirstFrame: firstFrame}}
+	}
+	return append([]*PlaylistFile{}, timer.Playlist.Files...)
+}
+
+func playlistFileAt(files []*PlaylistFile, frame uint64) *PlaylistFile {
+	playlist := &Playlist{Files: files}
+	file, _ := playlist.FileAt(frame)
+	if file == nil {
+		return files[0]
+	}
+	return file
+}
+
+// ReviewSession plays the session's video in the review player, and saves the corrections to -save, or back to -load
+func ReviewSession(session *Session, config *Config, args *Args, logger *slog.Logger) error {
+	timer, err := session.NewTimer()
+	if err != nil {
+		return err
+	}
+	timer.Logger = logger
+
+	if timer.Playlist != nil {
+		return fmt.Errorf("sessions timed from several videos cannot be reviewed in the player")
+	}
+
+	videoPath := args.VideoPath
+	if videoPath == "" {
+		videoPath = session.Source
+	}
+
+	savePath := args.SavePath
+	if savePath == "" {
+		savePath = args.LoadPath
+	}
+
+	// the timer is built from the session's config, so its gates (and their colors) come from there too
+	gateColors := map[string]color.RGBA{}
+	for _, gateConfig := range session.Config.Gates {
+		gateColors[gateConfig.Name] = GateColor2RGBA(gateConfig.Color.LowerBoundHSV, gateConfig.Color.UpperBoundHSV)
+	}
+
+	window := gocv.NewWindow("Review")
+	defer window.Close()
+
+	player, err := NewReviewPlayer(timer, window, videoPath, session.Config.FramesPerSec, session.SourceFirstFrame, gateColors)
+	if err != nil {
+		return err
+	}
+	defer player.Close()
+
+	player.Logger = logger
+	player.Save = func() error {
+		saved := NewSession(timer, session.Config, session.Source, session.FrameCount)
+		saved.SourceFirstFrame = session.SourceFirstFrame
+		saved.Signals = session.Signals
+		return saved.Save(savePath)
+	}
+
+	if err = player.Run(); err != nil {
+		return err
 	}
 
-	return videoPath, config, nil
+	PrintSummary(timer, config)
+	return nil
+}
+
+func PrintSummary(timer *Timer, config *Config) {
+	fmt.Println(timer.StatisticsSummary(timer.Statistics(config.Statistics.ConsecutiveLaps)))
+	fmt.Println(timer.SplitsSummary())
+	for _, correction := range timer.Corrections {
+		fmt.Println(correction)
+	}
 }
 
 func GateColor2Scalar(hsv []int) gocv.Scalar {
 	return gocv.NewScalar(float64(hsv[0]), float64(hsv[1]), float64(hsv[2]), 0.0)
 }
 
+// GateColor2RGBA is the color the gate is drawn with, the middle of its hue range at full saturation and value
+func GateColor2RGBA(lowerHSV []int, upperHSV []int) color.RGBA {
+	// OpenCV hues are 0-180
+	hue := float64(lowerHSV[0]+upperHSV[0]) / 2 * 2 / 60
+	x := uint8(255 * (1 - math.Abs(math.Mod(hue, 2)-1)))
+
+	switch int(hue) % 6 {
+	case 0:
+		return color.RGBA{R: 255, G: x, A: 255}
+	case 1:
+		return color.RGBA{R: x, G: 255, A: 255}
+	case 2:
+		return color.RGBA{G: 255, B: x, A: 255}
+	case 3:
+		return color.RGBA{G: x, B: 255, A: 255}
+	case 4:
+		return color.RGBA{R: x, B: 255, A: 255}
+	}
+	return color.RGBA{R: 255, B: x, A: 255}
+}
+
 func main() {
 
-	var videoPath string
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := RunExport(os.Args[2:]); err != nil {
+			fmt.Printf("%s: error: %s\n", filepath.Base(os.Args[0]), err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		if err := RunReport(os.Args[2:]); err != nil {
+			fmt.Printf("%s: error: %s\n", filepath.Base(os.Args[0]), err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	var args *Args
 	var config *Config
+	var session *Session
 	var err error
-	if videoPath, config, err = ProcessArgs(); err != nil {
+	if args, config, session, err = ProcessArgs(); err != nil {
+		panic(err)
+	}
+
+	var logger *slog.Logger
+	if logger, err = NewLogger(os.Stderr, config.Log.Format, config.Log.Level); err != nil {
 		panic(err)
 	}
 
-	fmt.Printf("%v\n", config)
+	var gateNames []string
+	for _, gateConfig := range config.Gates {
+		gateNames = append(gateNames, gateConfig.Name)
+	}
+	logger.Info("config loaded",
+		"config", args.ConfigPath,
+		"session", args.LoadPath,
+		"videos", args.VideoPaths,
+		"framesPerSec", config.FramesPerSec,
+		"gates", gateNames,
+		"raceStart", config.Race.Start.Mode)
+
+	if args.Review {
+		// review a saved session on its video, or on the -video
+		if err = ReviewSession(session, config, args, logger); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	if args.VideoPath == "" {
+		// review a saved session, without the video
+		var timer *Timer
+		if timer, err = session.NewTimer(); err != nil {
+			panic(err)
+		}
+		PrintSummary(timer, config)
+
+		if config.Clips.Dir != "" {
+			// from the video the session was recorded from
+			if err = CutClips(timer, config, session.Source, session.SourceFirstFrame); err != nil {
+				panic(err)
+			}
+		}
+
+		if config.Gallery.Dir != "" {
+			// the detector is only used for the marker masks
+			blank := gocv.NewMatWithSize(detectorHeight, detectorWidth, gocv.MatTypeCV8UC3)
+			defer blank.Close()
+			detector := NewDetector(blank, config.FramesPerSec, config.PropellerMask.Width, config.PropellerMask.Height)
+			for _, gate := range NewGatesFromConfig(config, blank) {
+				detector.AddGate(gate)
+			}
+			if err = CreateGallery(timer, config, session.Source, session.SourceFirstFrame, &detector, image.Pt(detectorWidth, detectorHeight)); err != nil {
+				panic(err)
+			}
+		}
+		return
+	}
 
-	dvr, _ := gocv.OpenVideoCapture(videoPath)
+	metrics := NewMetrics()
+
+	dvr, err := NewCapture(args.VideoPath, config.Capture.ReconnectAttempts, config.Capture.ReconnectDelayMillis, metrics)
+	if err != nil {
+		panic(err)
+	}
+	dvr.Logger = logger
 	dvrWindow := gocv.NewWindow("HDZero DVR")
 	binaryWindow := gocv.NewWindow("Binary Image")
 
-	width := 240
-	height := 180
+	width := detectorWidth
+	height := detectorHeight
 
 	img := gocv.NewMat()
 	resized := gocv.NewMat()
 	dvr.Read(&img)
 	gocv.Resize(img, &resized, image.Pt(width, height), 0, 0, gocv.InterpolationLinear)
 
-	var gates []*Gate
+	gates := NewGatesFromConfig(config, resized)
+
+	detector := NewDetector(resized, config.FramesPerSec, config.PropellerMask.Width, config.PropellerMask.Height)
+	timer := NewTimerFromConfig(config, gates)
+	timer.Events = NewEventBus(config.Server.EventHistory)
+	timer.Logger = logger
+	detector.Logger = logger
+	for _, gate := range gates {
+		detector.AddGate(gate)
+	}
 
+	// the frame offsets of a continued session start after the frames of the saved session
+	var firstFrame uint64
+	if session != nil {
+		// continue timing the saved session
+		if err = session.Restore(timer); err != nil {
+			panic(err)
+		}
+		timer.ResumeRace()
+		detector.SetFrameCount(session.FrameCount)
+		firstFrame = session.FrameCount
+	}
+
+	// only the frames in the ranges are processed, the frame offsets are still those of the whole video
+	ranges := NewFrameRanges(config.Ranges, config.FramesPerSec)
+	if len(ranges) > 0 && dvr.IsStream() {
+		panic(fmt.Errorf("ranges cannot be processed in a video stream"))
+	}
+
+	// the videos after the first one continue the session, with the frame offsets continuing from one to the next
+	nextVideo := 1
+	if len(args.VideoPaths) > 1 || timer.Playlist != nil {
+		if len(ranges) > 0 && len(args.VideoPaths) > 1 {
+			panic(fmt.Errorf("ranges cannot be processed in several videos"))
+		}
+		if timer.Playlist == nil {
+			timer.Playlist = NewPlaylist()
+		}
+		// a continued playlist session goes on with another video
+		timer.Playlist.Add(args.VideoPath, firstFrame, len(timer.Playlist.Files) > 0 && !config.Playlist.Continuous)
+	}
+
+	// the gate areas are saved with the session, for the report
+	var signals *SessionSignals
+	if args.SavePath != "" {
+		signals = NewSessionSignals()
+		if session != nil && session.Signals != nil {
+			signals = session.Signals
+		}
+	}
+
+	// the cue bounds are only set (and validated) in visual mode
+	var cueLowerBoundHSV, cueUpperBoundHSV gocv.Scalar
+	if config.Race.Start.Mode == RaceStartVisual {
+		cueLowerBoundHSV = GateColor2Scalar(config.Race.Start.Visual.LowerBoundHSV)
+		cueUpperBoundHSV = GateColor2Scalar(config.Race.Start.Visual.UpperBoundHSV)
+	}
+
+	raceStartDetector := NewRaceStartDetector(
+		resized,
+		config.Race.Start.Mode,
+		config.Race.Start.OffsetMillis,
+		config.FramesPerSec,
+		config.Race.Start.Motion.MinPixels,
+		cueLowerBoundHSV,
+		cueUpperBoundHSV,
+		config.Race.Start.Visual.MinArea)
+	defer raceStartDetector.Close()
+
+	var server *Server
+	if config.Server.Address != "" {
+		server = NewServer(config.Server.Address, timer, config.Statistics.ConsecutiveLaps)
+		server.Handle("/overlay", ServeOverlayPage(config.Server.Overlay.Theme, config.Server.Overlay.Accent))
+		server.Handle("/metrics", metrics.Handler())
+		if err = server.Start(); err != nil {
+			panic(err)
+		}
+	}
+
+	var hooks []*Hook
+	for _, hookConfig := range config.Hooks {
+		hook := NewHook(hookConfig.Events, hookConfig.Command, hookConfig.URL, hookConfig.TimeoutMillis)
+		hook.Logger = logger
+		hook.Start(timer.Events)
+		hooks = append(hooks, hook)
+	}
+
+	var mqttClient *MQTTClient
+	if config.MQTT.Broker != "" {
+		mqttClient = NewMQTTClient(
+			timer,
+			config.MQTT.Broker,
+			config.MQTT.ClientID,
+			config.MQTT.Username,
+			config.MQTT.Password,
+			config.MQTT.QoS,
+			config.MQTT.Topics.Events,
+			config.MQTT.Topics.State,
+			config.MQTT.Topics.Control)
+		mqttClient.Logger = logger
+		if err = mqttClient.Start(); err != nil {
+			panic(err)
+		}
+	}
+
+	overlay, err := NewOverlayFromConfig(config)
+	if err != nil {
+		panic(err)
+	}
+
+	var gateColors []color.RGBA
 	for _, gateConfig := range config.Gates {
-		gates = append(gates, NewGate(
-			gateConfig.Name,
-			resized,
-			GateColor2Scalar(gateConfig.Color.LowerBoundHSV),
-			GateColor2Scalar(gateConfig.Color.UpperBoundHSV),
-			gateConfig.Detection.MinMillisBetweenActivations,
-			gateConfig.Detection.MinActivationValue,
-			gateConfig.Detection.MinActivationFrames,
-			gateConfig.Detection.MinInactivationFrames))
+		gateColors = append(gateColors, GateColor2RGBA(gateConfig.Color.LowerBoundHSV, gateConfig.Color.UpperBoundHSV))
 	}
 
-	detector := NewDetector(resized, config.FramesPerSec, config.PropellerMask.Width, config.PropellerMask.Height)
-	timer := NewTimer()
-	for index, gate := range gates {
-		detector.AddGate(gate)
-		timer.AddGate(index, gate)
+	var videoWriter *AnnotatedVideoWriter
+	if config.Output.Video != "" {
+		if videoWriter, err = NewAnnotatedVideoWriter(
+			config.Output.Video,
+			config.Output.Codec,
+			config.FramesPerSec,
+			img.Cols(),
+			img.Rows(),
+			config.Output.Mask,
+			config.Output.Graph,
+			config.Output.FlashMillis,
+			gates,
+			gateColors); err != nil {
+			panic(err)
+		}
 	}
 
-	lapsMsg := fmt.Sprintf("Lap: 0, Time: 0")
-	transitionsMsg := fmt.Sprintf("Transition: ... -> ... , Time: 0")
+	var debugView *DebugView
+	if config.Debug.View {
+		debugWindow := gocv.NewWindow("Debug")
+		defer debugWindow.Close()
+		debugView = NewDebugView(debugWindow, width, height, config.FramesPerSec, config.Debug.Seconds, gates, gateColors)
+		defer debugView.Close()
+	}
 
 	var frameStart time.Time
 	var frameStop time.Time
 	for {
+		if len(ranges) > 0 {
+			// the video index of the frame the next read returns
+			nextFrame := detector.FrameCount() - firstFrame + 1
+			frameRange := NextFrameRange(ranges, nextFrame)
+			if frameRange == nil {
+				break
+			}
+			if frameRange.First > nextFrame {
+				if err = dvr.Seek(frameRange.First); err != nil {
+					panic(err)
+				}
+				detector.Skip(firstFrame + frameRange.First - 1)
+				logger.Info("skipped to range", "frame", frameRange.First, "millis", timer.Duration(int(frameRange.First)).Milliseconds())
+			}
+		}
+
+		readStart := time.Now()
 		if ok := dvr.Read(&img); !ok {
-			break
+			if nextVideo >= len(args.VideoPaths) {
+				break
+			}
+
+			if err = dvr.Close(); err != nil {
+				panic(err)
+			}
+			path := args.VideoPaths[nextVideo]
+			nextVideo += 1
+			if dvr, err = NewCapture(path, config.Capture.ReconnectAttempts, config.Capture.ReconnectDelayMillis, metrics); err != nil {
+				panic(err)
+			}
+			dvr.Logger = logger
+
+			timer.withLock(func() {
+				timer.Playlist.Add(path, detector.FrameCount()+1, !config.Playlist.Continuous)
+			})
+			if !config.Playlist.Continuous {
+				// a separate recording, the activation being built up at the end of the previous one is dropped
+				detector.Skip(detector.FrameCount())
+			}
+			logger.Info("next video", "path", path, "frame", detector.FrameCount()+1, "continuous", config.Playlist.Continuous)
+			continue
+		}
+		frameStart = metrics.ObserveStage(StageRead, readStart)
+		gocv.Resize(img, &resized, image.Pt(width, height), 0, 0, gocv.InterpolationArea)
+
+		detection := detector.Detect(&resized, binaryWindow)
+		// the timer may reject the detection, the debug view shows what the detector found
+		detected := detection
+		if signals != nil {
+			signals.Record(detector.FrameCount(), &detector, gates)
+		}
+		if gate, rejection := detector.Rejection(); gate != nil {
+			metrics.DetectionsRejected.WithLabelValues(gate.Name, rejection).Inc()
 		}
-		frameStart = time.Now()
-		gocv.Resize(img, &resized, image.Pt(240, 180), 0, 0, gocv.InterpolationArea)
+		stageStart := metrics.ObserveStage(StageDetect, frameStart)
 
-		if detection := detector.Detect(&resized, binaryWindow); detection != nil {
-			timer.AddDetection(detection)
-			if lastLap := timer.LastLap(); lastLap != nil {
-				lastLapTime := time.Duration(detector.MillisPerFrame()*lastLap.Frames()) * time.Millisecond
-				lapsMsg = fmt.Sprintf("Lap: %d, Time: %v, Gate: %s", timer.LapsCount(), lastLapTime, lastLap.Gate().Name)
+		timer.withLock(func() {
+			timer.CurrentFrame = detector.FrameCount()
 
+			if timer.RaceStart == nil && raceStartDetector.Detect(&resized, detector.FrameCount()) {
+				timer.StartRace(detector.FrameCount(), config.Race.Start.Mode)
+			}
+			if timer.RaceStart != nil {
+				// once started, automatically or by command, only the commands start the race again
+				raceStartDetector.Disarm()
 			}
 
-			if lastTransition := timer.LastTransition(); lastTransition != nil {
-				lastTransitionTime := time.Duration(detector.MillisPerFrame()*lastTransition.Frames()) * time.Millisecond
-				transitionsMsg = fmt.Sprintf("Transition: %s -> %s , Time: %v", lastTransition.start.Gate.Name, lastTransition.stop.Gate.Name, lastTransitionTime)
-			} else if lastDetection := timer.LastDetection(); lastDetection != nil {
-				transitionsMsg = fmt.Sprintf("Transition: %s -> ... , Time: 0", lastDetection.Gate.Name)
+			if detection != nil {
+				rejection := RejectedRaceWaiting
+				if timer.Finished {
+					rejection = RejectedRaceFinished
+				}
+
+				if timer.AddDetection(detection) {
+					metrics.DetectionsAccepted.WithLabelValues(detection.Gate.Name).Inc()
+				} else {
+					metrics.DetectionsRejected.WithLabelValues(detection.Gate.Name, rejection).Inc()
+					detection = nil
+				}
 			}
 
-			fmt.Println(lapsMsg)
-			fmt.Println(transitionsMsg)
-			fmt.Printf("%v\n\n", detection)
-		}
+			// the timer may also change through the HTTP API or MQTT
+			overlay.Update(timer)
+		})
 
-		frameStop = time.Now()
+		frameStop = metrics.ObserveStage(StageTimer, stageStart)
 
 		duration := frameStop.Sub(frameStart)
 
-		gocv.PutText(&img, lapsMsg, image.Pt(300, 100), gocv.FontHersheyDuplex, 1, color.RGBA{R: 255, G: 255, B: 255}, 1)
-		gocv.PutText(&img, transitionsMsg, image.Pt(300, 150), gocv.FontHersheyDuplex, 1, color.RGBA{R: 255, G: 255, B: 255}, 1)
-		gocv.PutText(&img, fmt.Sprintf("Frame latency: %v", duration), image.Pt(300, 200), gocv.FontHersheyDuplex, 1, color.RGBA{R: 255, G: 255, B: 255}, 1)
+		if videoWriter != nil {
+			// before the live overlay is drawn on the frame
+			if err = videoWriter.Write(img, overlay, &detector, detection); err != nil {
+				panic(err)
+			}
+		}
+
+		overlay.Draw(&img, duration)
 
 		dvrWindow.IMShow(img)
+		if debugView != nil {
+			debugView.Update(&resized, &detector, detected)
+		}
 		dvrWindow.WaitKey(1)
 
+		metrics.ObserveStage(StageRender, frameStop)
+		metrics.ObserveStage(StageTotal, readStart)
+		metrics.FramesProcessed.Inc()
+	}
+
+	timer.withLock(func() {
+		timer.FinishRace(detector.FrameCount())
+		PrintSummary(timer, config)
+
+		if args.SavePath != "" {
+			saved := NewSession(timer, config, args.VideoPath, detector.FrameCount())
+			saved.SourceFirstFrame = firstFrame
+			saved.Signals = signals
+			if err = saved.Save(args.SavePath); err != nil {
+				panic(err)
+			}
+		}
+	})
+
+	// let the hooks deliver the last events (e.g. race finish)
+	for _, hook := range hooks {
+		hook.Close()
+	}
+
+	if mqttClient != nil {
+		mqttClient.Close()
+	}
+
+	if server != nil {
+		if err = server.Close(); err != nil {
+			panic(err)
+		}
+	}
+
+	if videoWriter != nil {
+		if err = videoWriter.Close(); err != nil {
+			panic(err)
+		}
+	}
+
+	if config.Clips.Dir != "" {
+		if err = CutClips(timer, config, args.VideoPath, firstFrame); err != nil {
+			panic(err)
+		}
+	}
+
+	if config.Gallery.Dir != "" {
+		if err = CreateGallery(timer, config, args.VideoPath, firstFrame, &detector, image.Pt(width, height)); err != nil {
+			panic(err)
+		}
+	}
+
+	if err = dvr.Close(); err != nil {
+		panic(err)
 	}
 
 	if err := dvrWindow.Close(); err != nil {
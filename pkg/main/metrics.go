@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// frame processing stages, measured separately
+const (
+	StageRead   = "read"
+	StageDetect = "detect"
+	StageTimer  = "timer"
+	StageRender = "render"
+	StageTotal  = "total"
+)
+
+// Metrics exposes the health of the frame processing pipeline in the Prometheus format
+type Metrics struct {
+	FramesProcessed    prometheus.Counter
+	FramesDropped      prometheus.Counter
+	CaptureReconnects  prometheus.Counter
+	StageLatency       *prometheus.HistogramVec
+	DetectionsAccepted *prometheus.CounterVec
+	DetectionsRejected *prometheus.CounterVec
+	registry           *prometheus.Registry
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		FramesProcessed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "fpv_blob_timer_frames_processed_total",
+			Help: "Number of video frames processed.",
+		}),
+		FramesDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "fpv_blob_timer_frames_dropped_total",
+			Help: "Number of video frames that could not be read or decoded.",
+		}),
+		CaptureReconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "fpv_blob_timer_capture_reconnects_total",
+			Help: "Number of times the video stream was reopened after a failure.",
+		}),
+		StageLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "fpv_blob_timer_stage_latency_seconds",
+			Help: "Frame processing latency, per stage.",
+			// 0.25ms to ~0.5s
+			Buckets: prometheus.ExponentialBuckets(0.00025, 2, 12),
+		}, []string{"stage"}),
+		DetectionsAccepted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "fpv_blob_timer_detections_accepted_total",
+			Help: "Number of gate detections added to the timer, per gate.",
+		}, []string{"gate"}),
+		DetectionsRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "fpv_blob_timer_detections_rejected_total",
+			Help: "Number of gate activations that did not become a detection, per gate and reason.",
+		}, []string{"gate", "reason"}),
+		registry: prometheus.NewRegistry(),
+	}
+
+	m.registry.MustRegister(
+		m.FramesProcessed,
+		m.FramesDropped,
+		m.CaptureReconnects,
+		m.StageLatency,
+		m.DetectionsAccepted,
+		m.DetectionsRejected,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveStage records the time spent in a stage since the given start, and returns the current time for the next stage
+func (m *Metrics) ObserveStage(stage string, start time.Time) time.Time {
+	now := time.Now()
+	m.StageLatency.WithLabelValues(stage).Observe(now.Sub(start).Seconds())
+	return now
+}
@@ -0,0 +1,204 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const mqttTimeout = 5 * time.Second
+
+// mqttQueueSize is how many events may wait for a slow broker before new ones are dropped
+const mqttQueueSize = 64
+
+// mqttStateEvents are the events after which the retained state is published again
+var mqttStateEvents = map[string]bool{
+	EventLap:        true,
+	EventRaceStart:  true,
+	EventRaceFinish: true,
+	EventState:      true,
+}
+
+// MQTTClient publishes timer events to a broker, and executes race control commands received on the control topic.
+//
+// Like the hooks, events are published on the client's own goroutine, so a slow broker never stalls frame processing.
+type MQTTClient struct {
+	timer        *Timer
+	qos          byte
+	eventsTopic  string
+	stateTopic   string
+	controlTopic string
+
+	Logger *slog.Logger
+
+	client     mqtt.Client
+	subscriber chan *Event
+	queue      chan *Event
+	done       sync.WaitGroup
+}
+
+func NewMQTTClient(timer *Timer, broker string, clientID string, username string, password string, qos int, eventsTopic string, stateTopic string, controlTopic string) *MQTTClient {
+	c := &MQTTClient{
+		timer:        timer,
+		qos:          byte(qos),
+		eventsTopic:  eventsTopic,
+		stateTopic:   stateTopic,
+		controlTopic: controlTopic,
+		Logger:       discardLogger,
+		queue:        make(chan *Event, mqttQueueSize),
+	}
+
+	options := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetUsername(username).
+		SetPassword(password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		// the broker replaces the retained state when the timer goes away
+		SetWill(stateTopic, `{"state":"offline"}`, c.qos, true).
+		SetOnConnectHandler(c.onConnect)
+
+	c.client = mqtt.NewClient(options)
+
+	return c
+}
+
+func (c *MQTTClient) Start() error {
+	token := c.client.Connect()
+	if !token.WaitTimeout(mqttTimeout) {
+		// keeps retrying in the background
+		c.Logger.Warn("MQTT broker is not reachable yet, retrying")
+	} else if token.Error() != nil {
+		return fmt.Errorf("could not connect to MQTT broker. %s", token.Error().Error())
+	}
+
+	// no replay, the retained state is published on connect
+	c.subscriber, _ = c.timer.Events.Subscribe(^uint64(0))
+
+	c.done.Add(2)
+
+	go func() {
+		defer c.done.Done()
+		defer close(c.queue)
+
+		for event := range c.subscriber {
+			select {
+			case c.queue <- event:
+			default:
+				c.Logger.Warn("MQTT broker is too slow, event dropped", "event", event.Type, "seq", event.Seq)
+			}
+		}
+	}()
+
+	go func() {
+		defer c.done.Done()
+
+		for event := range c.queue {
+			c.publishEvent(event)
+			if mqttStateEvents[event.Type] {
+				c.publishState()
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (c *MQTTClient) Close() {
+	if c.subscriber != nil {
+		c.timer.Events.Unsubscribe(c.subscriber)
+		c.done.Wait()
+	}
+	c.client.Disconnect(uint(mqttTimeout.Milliseconds()))
+}
+
+// onConnect runs on every (re)connect, the subscription and the retained state are not kept by the broker for a clean session
+func (c *MQTTClient) onConnect(client mqtt.Client) {
+	if c.controlTopic != "" {
+		token := client.Subscribe(c.controlTopic, c.qos, c.onControl)
+		if token.WaitTimeout(mqttTimeout) && token.Error() != nil {
+			c.Logger.Error("could not subscribe to MQTT topic", "topic", c.controlTopic, "reason", token.Error().Error())
+		}
+	}
+	c.publishState()
+}
+
+// onControl executes the commands of the control topic
+func (c *MQTTClient) onControl(client mqtt.Client, message mqtt.Message) {
+	command, err := parseControlPayload(message.Payload())
+	if err != nil {
+		c.Logger.Warn("invalid MQTT command", "topic", message.Topic(), "reason", err.Error())
+		return
+	}
+
+	c.timer.withLock(func() {
+		_, err = c.timer.Execute(command)
+	})
+
+	if err != nil {
+		c.Logger.Warn("could not execute MQTT command", "command", command.Name, "reason", err.Error())
+	}
+}
+
+// parseControlPayload accepts a command as JSON (same as the HTTP API), or just its name, e.g. "start"
+func parseControlPayload(payload []byte) (*Command, error) {
+	text := strings.TrimSpace(string(payload))
+
+	command := &Command{}
+	if strings.HasPrefix(text, "{") {
+		if err := json.Unmarshal([]byte(text), command); err != nil {
+			return nil, err
+		}
+	} else {
+		command.Name = text
+	}
+	if command.Author == "" {
+		command.Author = "mqtt"
+	}
+	return command, nil
+}
+
+func (c *MQTTClient) publishEvent(event *Event) {
+	payload, err := event.JSON()
+	if err != nil {
+		return
+	}
+	c.publish(c.eventsTopic+"/"+event.Type, false, payload)
+}
+
+func (c *MQTTClient) publishState() {
+	var state *TimerState
+	c.timer.withLock(func() {
+		state = NewTimerState(c.timer)
+	})
+
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(state); err != nil {
+		return
+	}
+	c.publish(c.stateTopic, true, bytes.TrimSuffix(buffer.Bytes(), []byte("\n")))
+}
+
+func (c *MQTTClient) publish(topic string, retained bool, payload []byte) {
+	if !c.client.IsConnectionOpen() {
+		return
+	}
+
+	token := c.client.Publish(topic, c.qos, retained, payload)
+	if token.WaitTimeout(mqttTimeout) && token.Error() != nil {
+		c.Logger.Error("could not publish to MQTT topic", "topic", topic, "reason", token.Error().Error())
+	}
+}
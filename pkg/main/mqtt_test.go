@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseControlPayload(t *testing.T) {
+	frameOffset := uint64(2400)
+
+	tests := []struct {
+		name    string
+		payload string
+		want    *Command
+	}{
+		{"name", "start", &Command{Name: CommandStart, Author: "mqtt"}},
+		{"name with newline", "reset\n", &Command{Name: CommandReset, Author: "mqtt"}},
+		{
+			"json",
+			`{"command": "detection", "gate": "a", "frameOffset": 2400, "author": "director", "reason": "missed"}`,
+			&Command{Name: CommandDetection, Gate: "a", FrameOffset: &frameOffset, Author: "director", Reason: "missed"},
+		},
+		{"json without author", ` {"command": "stop"}`, &Command{Name: CommandStop, Author: "mqtt"}},
+		{"invalid json", `{"command": `, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			command, err := parseControlPayload([]byte(test.payload))
+			if test.want == nil {
+				if err == nil {
+					t.Errorf("got command %+v, want an error", command)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(command, test.want) {
+				t.Errorf("got command %+v, want %+v", command, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"fmt"
+	"gocv.io/x/gocv"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	OverlayLaps       = "laps"
+	OverlayTransition = "transition"
+	OverlayLatency    = "latency"
+	OverlayHoleshot   = "holeshot"
+	OverlaySplits     = "splits"
+)
+
+var OverlayContents = []string{OverlayLaps, OverlayTransition, OverlayLatency, OverlayHoleshot, OverlaySplits}
+
+const (
+	OverlayAlignLeft   = "left"
+	OverlayAlignCenter = "center"
+	OverlayAlignRight  = "right"
+)
+
+// the layout is defined for a 720 pixel high frame, and scaled to the frame height
+const overlayReferenceHeight = 720
+
+// OverlayElement is a piece of the overlay text at a position of the frame
+type OverlayElement struct {
+	Content string
+	// where the text starts, on its baseline, as a fraction of the frame width and height
+	X float64
+	Y float64
+	// the text is left of, centered on, or right of X
+	Align      string
+	FontScale  float64
+	Color      color.RGBA
+	Background bool
+}
+
+// Overlay is the text drawn on top of the DVR video
+type Overlay struct {
+	LapsMsg        string
+	TransitionsMsg string
+	HoleshotMsg    string
+	SplitsMsgs     []string
+
+	elements          []*OverlayElement
+	backgroundColor   color.RGBA
+	backgroundOpacity float64
+
+	revision uint64
+}
+
+func NewOverlay(elements []*OverlayElement, backgroundColor color.RGBA, backgroundOpacity float64) *Overlay {
+	return &Overlay{
+		LapsMsg:           fmt.Sprintf("Lap: 0, Time: 0"),
+		TransitionsMsg:    fmt.Sprintf("Transition: ... -> ... , Time: 0"),
+		elements:          elements,
+		backgroundColor:   backgroundColor,
+		backgroundOpacity: backgroundOpacity,
+	}
+}
+
+// Update refreshes the messages if the timer changed since the last update, the caller must hold the timer lock
+func (o *Overlay) Update(timer *Timer) {
+	if timer.Revision == o.revision {
+		return
+	}
+	o.revision = timer.Revision
+
+	o.LapsMsg = fmt.Sprintf("Lap: 0, Time: 0")
+	if lastLap := timer.LastLap(); lastLap != nil {
+		lastLapTime := timer.Duration(lastLap.Frames())
+		o.LapsMsg = fmt.Sprintf("Lap: %d, Time: %v, Gate: %s", timer.LapsCount(), lastLapTime, lastLap.Gate().Name)
+		if lastLap.HasInferredCrossing() {
+			o.LapsMsg = fmt.Sprintf("%s, inferred", o.LapsMsg)
+		}
+		if !lastLap.IsValid() {
+			o.LapsMsg = fmt.Sprintf("%s, %s", o.LapsMsg, lastLap.Status)
+		}
+	}
+
+	o.TransitionsMsg = fmt.Sprintf("Transition: ... -> ... , Time: 0")
+	if lastTransition := timer.LastTransition(); lastTransition != nil {
+		lastTransitionTime := timer.Duration(lastTransition.Frames())
+		o.TransitionsMsg = fmt.Sprintf("Transition: %s -> %s , Time: %v", lastTransition.start.Gate.Name, lastTransition.stop.Gate.Name, lastTransitionTime)
+	} else if lastDetection := timer.LastDetection(); lastDetection != nil {
+		o.TransitionsMsg = fmt.Sprintf("Transition: %s -> ... , Time: 0", lastDetection.Gate.Name)
+	}
+
+	o.SplitsMsgs = o.SplitsMsgs[:0]
+	if lastLap := timer.LastLap(); lastLap != nil {
+		lapSplits := timer.LapSplits(lastLap)
+		for _, sector := range timer.Sectors() {
+			if split := lapSplits.Split(sector.Key); split != nil {
+				o.SplitsMsgs = append(o.SplitsMsgs, fmt.Sprintf("%s: %v (best %v)", sector.Key, timer.Duration(split.Frames()), timer.Duration(sector.Best.Frames())))
+			}
+		}
+		if theoreticalBest, ok := timer.TheoreticalBest(); ok {
+			o.SplitsMsgs = append(o.SplitsMsgs, fmt.Sprintf("Theoretical best: %v", timer.Duration(theoreticalBest)))
+		}
+	}
+
+	o.HoleshotMsg = ""
+	if timer.Holeshot != nil {
+		o.HoleshotMsg = fmt.Sprintf("Holeshot: %v", timer.Duration(timer.Holeshot.Frames()))
+	} else if timer.RaceStart != nil && timer.RaceStart.Source != RaceStartGate {
+		o.HoleshotMsg = "Holeshot: ..."
+	}
+}
+
+// lines is the text of the content, the frame latency is left out when it's 0 (e.g. for the output video)
+func (o *Overlay) lines(content string, latency time.Duration) []string {
+	switch content {
+	case OverlayLaps:
+		return []string{o.LapsMsg}
+	case OverlayTransition:
+		return []string{o.TransitionsMsg}
+	case OverlayLatency:
+		if latency > 0 {
+			return []string{fmt.Sprintf("Frame latency: %v", latency)}
+		}
+	case OverlayHoleshot:
+		if o.HoleshotMsg != "" {
+			return []string{o.HoleshotMsg}
+		}
+	case OverlaySplits:
+		return o.SplitsMsgs
+	}
+	return nil
+}
+
+// Draw puts the elements on the image, scaled to its height
+func (o *Overlay) Draw(img *gocv.Mat, latency time.Duration) {
+	scale := float64(img.Rows()) / overlayReferenceHeight
+
+	for _, element := range o.elements {
+		fontScale := element.FontScale * scale
+		thickness := int(math.Max(1, math.Round(fontScale)))
+		y := int(element.Y * float64(img.Rows()))
+
+		for _, line := range o.lines(element.Content, latency) {
+			size, baseline := gocv.GetTextSizeWithBaseline(line, gocv.FontHersheyDuplex, fontScale, thickness)
+
+			x := int(element.X * float64(img.Cols()))
+			switch element.Align {
+			case OverlayAlignCenter:
+				x -= size.X / 2
+			case OverlayAlignRight:
+				x -= size.X
+			}
+
+			if element.Background {
+				padding := int(8 * scale)
+				box := image.Rect(x-padding, y-size.Y-padding, x+size.X+padding, y+baseline+padding)
+				o.drawBackground(img, box)
+			}
+
+			gocv.PutText(img, line, image.Pt(x, y), gocv.FontHersheyDuplex, fontScale, element.Color, thickness)
+			// the next line of a multi-line content (e.g. splits) goes below
+			y += size.Y + baseline + size.Y/2
+		}
+	}
+}
+
+// drawBackground blends a box of the background color into the image
+func (o *Overlay) drawBackground(img *gocv.Mat, box image.Rectangle) {
+	box = box.Intersect(image.Rect(0, 0, img.Cols(), img.Rows()))
+	if box.Empty() {
+		return
+	}
+
+	if o.backgroundOpacity >= 1 {
+		gocv.Rectangle(img, box, o.backgroundColor, -1)
+		return
+	}
+
+	region := img.Region(box)
+	defer region.Close()
+	background := gocv.NewMatWithSizeFromScalar(
+		gocv.NewScalar(float64(o.backgroundColor.B), float64(o.backgroundColor.G), float64(o.backgroundColor.R), 0),
+		box.Dy(), box.Dx(), gocv.MatTypeCV8UC3)
+	defer background.Close()
+	gocv.AddWeighted(region, 1-o.backgroundOpacity, background, o.backgroundOpacity, 0, &region)
+}
+
+// ParseHexColor parses a #rrggbb color
+func ParseHexColor(hex string) (color.RGBA, error) {
+	if len(hex) != 7 || !strings.HasPrefix(hex, "#") {
+		return color.RGBA{}, fmt.Errorf("color %q is not in #rrggbb format", hex)
+	}
+	value, err := strconv.ParseUint(hex[1:], 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("color %q is not in #rrggbb format", hex)
+	}
+	return color.RGBA{R: uint8(value >> 16), G: uint8(value >> 8), B: uint8(value), A: 255}, nil
+}
@@ -0,0 +1,37 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	_ "embed"
+	"html/template"
+	"net/http"
+)
+
+//go:embed web/overlay.html
+var overlayPageHTML string
+
+var overlayPageTemplate = template.Must(template.New("overlay").Parse(overlayPageHTML))
+
+type overlayPageData struct {
+	Theme string
+	// not escaped, Config.Validate only accepts CSS colors
+	Accent template.CSS
+}
+
+// ServeOverlayPage serves the HTML overlay for streaming software, fed by the event stream
+func ServeOverlayPage(theme string, accent string) http.HandlerFunc {
+	data := &overlayPageData{
+		Theme:  theme,
+		Accent: template.CSS(accent),
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := overlayPageTemplate.Execute(w, data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"fmt"
+	"gocv.io/x/gocv"
+	"image"
+	"image/color"
+	"log/slog"
+)
+
+const (
+	reviewAuthor = "review"
+	reviewReason = "marked in the review player"
+	// how often the keyboard is checked while paused
+	reviewPausedDelayMillis = 30
+	// the delete key removes the closest detection within this time of the current frame
+	reviewDeleteWindowMillis = 250
+	reviewTimelineHeight     = 24
+	reviewHelp               = "space play/pause  a/d frame  j/l 1s  J/L 10s  p/n detection  1-9 add  x delete  s save  q quit"
+)
+
+// ReviewPlayer plays the video of a timed session, with keyboard controls to step through it and correct its detections
+type ReviewPlayer struct {
+	timer        *Timer
+	window       *gocv.Window
+	framesPerSec int
+	firstFrame   uint64
+	lastFrame    uint64
+	gates        []*Gate
+	gateColors   map[*Gate]color.RGBA
+	// saves the corrected session, called within the timer lock
+	Save   func() error
+	Logger *slog.Logger
+
+	_video   *gocv.VideoCapture
+	_img     gocv.Mat
+	_frame   gocv.Mat
+	_display gocv.Mat
+	// frame offset of the frame shown, and of the frame the video reads next
+	_position  uint64
+	_nextFrame uint64
+	_paused    bool
+	_changed   bool
+	_message   string
+}
+
+// NewReviewPlayer opens the video for review, firstFrame is the frame offset of the first frame of the video.
+// The gate colors are looked up by gate name.
+func NewReviewPlayer(timer *Timer, window *gocv.Window, videoPath string, framesPerSec int, firstFrame uint64, gateColors map[string]color.RGBA) (*ReviewPlayer, error) {
+	video, err := gocv.OpenVideoCapture(videoPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not open video %s. %s", videoPath, err.Error())
+	}
+
+	frameCount := video.Get(gocv.VideoCaptureFrameCount)
+	if frameCount < 1 {
+		_ = video.Close()
+		return nil, fmt.Errorf("video %s has no frame count, streams cannot be reviewed", videoPath)
+	}
+
+	p := &ReviewPlayer{
+		timer:        timer,
+		window:       window,
+		framesPerSec: framesPerSec,
+		firstFrame:   firstFrame,
+		lastFrame:    firstFrame + uint64(frameCount) - 1,
+		gateColors:   map[*Gate]color.RGBA{},
+		Logger:       discardLogger,
+
+		_video:     video,
+		_img:       gocv.NewMat(),
+		_frame:     gocv.NewMat(),
+		_display:   gocv.NewMat(),
+		_nextFrame: firstFrame,
+		_paused:    true,
+	}
+
+	for i := 0; i < len(timer.GatesByPosition); i++ {
+		gate := timer.GatesByPosition[i]
+		p.gates = append(p.gates, gate)
+		p.gateColors[gate] = gateColors[gate.Name]
+	}
+
+	return p, nil
+}
+
+// Run plays the video until it's closed with q or escape, and saves the corrections if there are any
+func (p *ReviewPlayer) Run() error {
+	p.seek(p.firstFrame)
+	if p._frame.Empty() {
+		return fmt.Errorf("could not read the first frame of the video")
+	}
+
+	for {
+		if !p._paused && !p.read() {
+			p._paused = true
+			p._message = "end of video"
+		}
+		p.render()
+
+		delay := reviewPausedDelayMillis
+		if !p._paused {
+			delay = 1000 / p.framesPerSec
+		}
+		key := p.window.WaitKey(delay)
+		if key < 0 {
+			continue
+		}
+
+		quit, err := p.handleKey(key)
+		if err != nil {
+			return err
+		}
+		if quit {
+			break
+		}
+	}
+
+	if p._changed {
+		return p.save()
+	}
+	return nil
+}
+
+func (p *ReviewPlayer) handleKey(key int) (bool, error) {
+	frames := func(millis int) int64 {
+		return int64(millis * p.framesPerSec / 1000)
+	}
+
+	switch key {
+	case ' ':
+		p._paused = !p._paused
+	case 'd':
+		p._paused = true
+		p.seekBy(1)
+	case 'a':
+		p._paused = true
+		p.seekBy(-1)
+	case 'l':
+		p.seekBy(frames(1000))
+	case 'j':
+		p.seekBy(frames(-1000))
+	case 'L':
+		p.seekBy(frames(10000))
+	case 'J':
+		p.seekBy(frames(-10000))
+	case 'n':
+		p.seekDetection(true)
+	case 'p':
+		p.seekDetection(false)
+	case '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		p.insertDetection(key - '1')
+	case 'x':
+		p.removeDetection(frames(reviewDeleteWindowMillis))
+	case 's':
+		if err := p.save(); err != nil {
+			return false, err
+		}
+	case 'q', 27:
+		return true, nil
+	}
+	return false, nil
+}
+
+// read shows the next frame of the video, it returns false at the end of the video
+func (p *ReviewPlayer) read() bool {
+	if ok := p._video.Read(&p._img); !ok || p._img.Empty() {
+		return false
+	}
+	p._img.CopyTo(&p._frame)
+	p._position = p._nextFrame
+	p._nextFrame += 1
+	return true
+}
+
+// seek shows the frame with the given offset, within the video
+func (p *ReviewPlayer) seek(frameOffset uint64) {
+	if frameOffset < p.firstFrame {
+		frameOffset = p.firstFrame
+	}
+	if frameOffset > p.lastFrame {
+		frameOffset = p.lastFrame
+	}
+
+	// stepping forward doesn't need a seek
+	if frameOffset != p._nextFrame {
+		p._video.Set(gocv.VideoCapturePosFrames, float64(frameOffset-p.firstFrame))
+		p._nextFrame = frameOffset
+	}
+	p.read()
+}
+
+func (p *ReviewPlayer) seekBy(frames int64) {
+	frameOffset := int64(p._position) + frames
+	if frameOffset < 0 {
+		frameOffset = 0
+	}
+	p.seek(uint64(frameOffset))
+}
+
+// seekDetection jumps to the next (or previous) detection
+func (p *ReviewPlayer) seekDetection(next bool) {
+	var target *Detection
+	p.timer.withLock(func() {
+		for _, detection := range p.timer.DetectionsInOrder {
+			if next && detection.FrameOffset > p._position {
+				target = detection
+				break
+			}
+			if !next && detection.FrameOffset < p._position {
+				target = detection
+			}
+		}
+	})
+
+	if target == nil {
+		p._message = "no more detections"
+		return
+	}
+	p._paused = true
+	p.seek(target.FrameOffset)
+	p._message = fmt.Sprintf("detection %d, gate %s, %s", target.ID, target.Gate.Name, target.Origin)
+}
+
+// insertDetection adds a manual detection for the gate at the given position at the current frame
+func (p *ReviewPlayer) insertDetection(position int) {
+	if position >= len(p.gates) {
+		p._message = fmt.Sprintf("there is no gate %d", position+1)
+		return
+	}
+	gate := p.gates[position]
+
+	p.timer.withLock(func() {
+		detection, err := p.timer.InsertDetection(gate.Name, p._position, reviewAuthor, reviewReason)
+		if err != nil {
+			p._message = err.Error()
+			return
+		}
+		p._changed = true
+		p._message = fmt.Sprintf("added detection %d, gate %s", detection.ID, gate.Name)
+	})
+}
+
+// removeDetection deletes the detection closest to the current frame, if it is within the given frames.
+// Inferred detections are left out, since they are recomputed from the others.
+func (p *ReviewPlayer) removeDetection(withinFrames int64) {
+	p.timer.withLock(func() {
+		var closest *Detection
+		var closestDistance int64
+		for _, detection := range p.timer.DetectionsInOrder {
+			if detection.IsInferred() {
+				continue
+			}
+			distance := int64(detection.FrameOffset) - int64(p._position)
+			if distance < 0 {
+				distance = -distance
+			}
+			if distance <= withinFrames && (closest == nil || distance < closestDistance) {
+				closest = detection
+				closestDistance = distance
+			}
+		}
+
+		if closest == nil {
+			p._message = "no detection at this frame"
+			return
+		}
+
+		if err := p.timer.RemoveDetection(closest.ID, reviewAuthor, reviewReason); err != nil {
+			p._message = err.Error()
+			return
+		}
+		p._changed = true
+		p._message = fmt.Sprintf("deleted detection %d, gate %s", closest.ID, closest.Gate.Name)
+	})
+}
+
+func (p *ReviewPlayer) save() error {
+	if p.Save == nil {
+		return nil
+	}
+
+	var err error
+	p.timer.withLock(func() {
+		if err = p.Save(); err != nil {
+			return
+		}
+		p._changed = false
+		p._message = "session saved"
+		p.Logger.Info("review saved", "detections", len(p.timer.DetectionsInOrder), "corrections", len(p.timer.Corrections))
+	})
+	return err
+}
+
+// lapsAt returns the number of laps completed at the frame, and the last of them
+func (p *ReviewPlayer) lapsAt(frameOffset uint64) (int, *Lap) {
+	count := 0
+	var last *Lap
+	for _, lap := range p.timer.Laps {
+		if lap.stopFrame <= frameOffset {
+			count += 1
+			last = lap
+		}
+	}
+	return count, last
+}
+
+func (p *ReviewPlayer) render() {
+	p._frame.CopyTo(&p._display)
+	width := p._display.Cols()
+	height := p._display.Rows()
+	scale := float64(width) / 1280
+	white := color.RGBA{R: 255, G: 255, B: 255}
+	line := func(text string, y int) {
+		gocv.PutText(&p._display, text, image.Pt(20, int(float64(y)*scale)), gocv.FontHersheyDuplex, scale, white, 1)
+	}
+
+	p.timer.withLock(func() {
+		status := ""
+		if p._paused {
+			status = "paused"
+		}
+		if p._changed {
+			status += " *"
+		}
+		line(fmt.Sprintf("Frame: %d, Time: %s %s", p._position, formatLapTime(p.timer.Duration(int(p._position)).Milliseconds()), status), 40)
+
+		laps, lastLap := p.lapsAt(p._position)
+		lapsMsg := fmt.Sprintf("Lap: %d", laps+1)
+		if lastLap != nil {
+			lapsMsg = fmt.Sprintf("%s, Last lap: %s, %s", lapsMsg, formatLapTime(p.timer.Duration(lastLap.Frames()).Milliseconds()), lastLap.Status)
+		}
+		line(lapsMsg, 80)
+
+		if p._message != "" {
+			line(p._message, 120)
+		}
+
+		// a border in the gate color on the frame of a detection
+		for _, detection := range p.timer.DetectionsInOrder {
+			if detection.FrameOffset == p._position {
+				gocv.Rectangle(&p._display, image.Rect(0, 0, width, height), p.gateColors[detection.Gate], 8)
+				line(fmt.Sprintf("Detection %d, gate %s, %s", detection.ID, detection.Gate.Name, detection.Origin), 160)
+			}
+		}
+
+		// the timeline of the video with its detections
+		top := height - reviewTimelineHeight
+		gocv.Rectangle(&p._display, image.Rect(0, top, width, height), color.RGBA{R: 22, G: 22, B: 26}, -1)
+		x := func(frameOffset uint64) int {
+			return int((frameOffset - p.firstFrame) * uint64(width) / (p.lastFrame - p.firstFrame + 1))
+		}
+		for _, detection := range p.timer.DetectionsInOrder {
+			if detection.FrameOffset < p.firstFrame || detection.FrameOffset > p.lastFrame {
+				continue
+			}
+			gocv.Line(&p._display, image.Pt(x(detection.FrameOffset), top+4), image.Pt(x(detection.FrameOffset), height-4), p.gateColors[detection.Gate], 2)
+		}
+		gocv.Line(&p._display, image.Pt(x(p._position), top), image.Pt(x(p._position), height), white, 2)
+
+		gocv.PutText(&p._display, reviewHelp, image.Pt(20, top-int(10*scale)), gocv.FontHersheyPlain, scale*1.2, white, 1)
+
+		p.window.IMShow(p._display)
+	})
+}
+
+func (p *ReviewPlayer) Close() error {
+	_ = p._img.Close()
+	_ = p._frame.Close()
+	_ = p._display.Close()
+	return p._video.Close()
+}
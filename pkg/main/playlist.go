@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// PlaylistFile is one of the videos a session is timed from
+type PlaylistFile struct {
+	Path string `json:"path"`
+	// frame offset of the first frame of the video in the session
+	FirstFrame uint64 `json:"firstFrame"`
+	// the video is a separate recording, rather than the continuation of the previous one
+	Gap bool `json:"gap,omitempty"`
+}
+
+// Playlist is the videos of a session timed from several videos, in the order they were timed.
+// The frame offsets continue from one video to the next.
+type Playlist struct {
+	Files []*PlaylistFile
+}
+
+func NewPlaylist() *Playlist {
+	return &Playlist{
+		Files: []*PlaylistFile{},
+	}
+}
+
+func (p *Playlist) Add(path string, firstFrame uint64, gap bool) {
+	p.Files = append(p.Files, &PlaylistFile{
+		Path:       path,
+		FirstFrame: firstFrame,
+		Gap:        gap,
+	})
+}
+
+// FileAt returns the video the frame is in, and the index of the frame in that video
+func (p *Playlist) FileAt(frame uint64) (*PlaylistFile, uint64) {
+	var file *PlaylistFile
+	for _, f := range p.Files {
+		if f.FirstFrame > frame {
+			break
+		}
+		file = f
+	}
+	if file == nil {
+		return nil, frame
+	}
+	return file, frame - file.FirstFrame
+}
+
+// HasGap is true when a separate recording starts after the start frame, up to the stop frame
+func (p *Playlist) HasGap(startFrame uint64, stopFrame uint64) bool {
+	for _, file := range p.Files {
+		if file.Gap && file.FirstFrame > startFrame && file.FirstFrame <= stopFrame {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpandVideoPaths replaces the glob patterns (e.g. DVR/*.ts) with the files they match, in name order.
+// Streams (e.g. rtsp://) are kept as they are.
+func ExpandVideoPaths(patterns []string) ([]string, error) {
+	var paths []string
+	for _, pattern := range patterns {
+		if strings.Contains(pattern, "://") || !strings.ContainsAny(pattern, "*?[") {
+			paths = append(paths, pattern)
+			continue
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid video pattern %s. %s", pattern, err.Error())
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no videos match %s", pattern)
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testPlaylist has a.ts from frame 0, b.ts from frame 1000 continuing it, and c.ts from frame 2500 as a separate recording
+func testPlaylist() *Playlist {
+	playlist := NewPlaylist()
+	playlist.Add("a.ts", 0, false)
+	playlist.Add("b.ts", 1000, false)
+	playlist.Add("c.ts", 2500, true)
+	return playlist
+}
+
+func TestPlaylistFileAt(t *testing.T) {
+	playlist := testPlaylist()
+
+	tests := []struct {
+		frame      uint64
+		path       string
+		videoFrame uint64
+	}{
+		{0, "a.ts", 0},
+		{999, "a.ts", 999},
+		{1000, "b.ts", 0},
+		{2499, "b.ts", 1499},
+		{2500, "c.ts", 0},
+		{10000, "c.ts", 7500},
+	}
+
+	for _, test := range tests {
+		file, videoFrame := playlist.FileAt(test.frame)
+		if file == nil || file.Path != test.path || videoFrame != test.videoFrame {
+			t.Errorf("frame %d: got %v frame %d, want %s frame %d", test.frame, file, videoFrame, test.path, test.videoFrame)
+		}
+	}
+
+	// a session continued with a playlist starts after the frames of the saved session
+	continued := NewPlaylist()
+	continued.Add("d.ts", 500, false)
+	if file, frame := continued.FileAt(100); file != nil || frame != 100 {
+		t.Errorf("got %v frame %d before the first video, want none", file, frame)
+	}
+}
+
+func TestPlaylistHasGap(t *testing.T) {
+	playlist := testPlaylist()
+
+	tests := []struct {
+		name  string
+		start uint64
+		stop  uint64
+		want  bool
+	}{
+		{"within a video", 100, 900, false},
+		{"into a continuing video", 900, 1100, false},
+		{"into a separate recording", 2400, 2600, true},
+		{"ending on the first frame of a separate recording", 2400, 2500, true},
+		{"starting on the first frame of a separate recording", 2500, 2600, false},
+		{"over all videos", 0, 3000, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := playlist.HasGap(test.start, test.stop); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestExpandVideoPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"DVR0002.ts", "DVR0001.ts", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths, err := ExpandVideoPaths([]string{filepath.Join(dir, "*.ts"), "rtsp://goggles/live", "missing.ts"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "DVR0001.ts"), filepath.Join(dir, "DVR0002.ts"), "rtsp://goggles/live", "missing.ts"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("got %v, want %v", paths, want)
+	}
+
+	if _, err = ExpandVideoPaths([]string{filepath.Join(dir, "*.mp4")}); err == nil {
+		t.Errorf("got no error for a pattern without matches")
+	}
+}
+
+func TestPlaylistGapLap(t *testing.T) {
+	timer := testTimer(nil, []string{"start"})
+	timer.Playlist = testPlaylist()
+	for _, frame := range []uint64{100, 900, 1500, 2600} {
+		timer.AddDetection(&Detection{Gate: timer.GatesByName["start"], FrameOffset: frame, Origin: DetectionOriginDetector})
+	}
+
+	var statuses []LapStatus
+	for _, lap := range timer.Laps {
+		statuses = append(statuses, lap.Status)
+	}
+	// only the lap from b.ts into c.ts spans a gap
+	if want := []LapStatus{LapValid, LapValid, LapInvalid}; !reflect.DeepEqual(statuses, want) {
+		t.Errorf("got laps %v, want %v", statuses, want)
+	}
+}
@@ -0,0 +1,41 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import "fmt"
+
+const (
+	RaceStartGate   = "gate"
+	RaceStartTime   = "time"
+	RaceStartVisual = "visual"
+	RaceStartMotion = "motion"
+	RaceStartManual = "manual"
+)
+
+type RaceStart struct {
+	FrameOffset uint64
+	Source      string
+}
+
+func (r *RaceStart) String() string {
+	return fmt.Sprintf("source: %s, frame: %v", r.Source, r.FrameOffset)
+}
+
+// Holeshot is the time from the race start to the first pass through the start gate
+type Holeshot struct {
+	start *RaceStart
+	stop  *Detection
+}
+
+func NewHoleshot(start *RaceStart, stop *Detection) *Holeshot {
+	return &Holeshot{
+		start: start,
+		stop:  stop,
+	}
+}
+
+func (h *Holeshot) Frames() int {
+	return int(h.stop.FrameOffset - h.start.FrameOffset)
+}
@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"gocv.io/x/gocv"
+)
+
+// RaceStartDetector decides on which frame the race begins.
+//
+// Depending on the mode, the race starts at a fixed time offset into the video,
+// when a visual cue (e.g. a start light or a colored card held in front of the camera) comes into view,
+// or when the camera image starts moving (the drone takes off).
+type RaceStartDetector struct {
+	mode            string
+	offsetFrames    uint64
+	minMotionPixels int
+	minCueArea      int
+	disarmed        bool
+
+	_cueLowerBoundHSV gocv.Mat
+	_cueUpperBoundHSV gocv.Mat
+
+	_hsvImg      gocv.Mat
+	_cueMask     gocv.Mat
+	_grayImg     gocv.Mat
+	_lastGrayImg gocv.Mat
+	_diffImg     gocv.Mat
+}
+
+func NewRaceStartDetector(img gocv.Mat,
+	mode string,
+	offsetMillis int,
+	framesPerSec int,
+	minMotionPixels int,
+	cueLowerBoundHSV gocv.Scalar,
+	cueUpperBoundHSV gocv.Scalar,
+	minCueArea int) *RaceStartDetector {
+	return &RaceStartDetector{
+		mode:              mode,
+		offsetFrames:      uint64(offsetMillis * framesPerSec / 1000),
+		minMotionPixels:   minMotionPixels,
+		minCueArea:        minCueArea,
+		_cueLowerBoundHSV: gocv.NewMatWithSizeFromScalar(cueLowerBoundHSV, img.Rows(), img.Cols(), gocv.MatTypeCV8UC3),
+		_cueUpperBoundHSV: gocv.NewMatWithSizeFromScalar(cueUpperBoundHSV, img.Rows(), img.Cols(), gocv.MatTypeCV8UC3),
+		_hsvImg:           gocv.NewMat(),
+		_cueMask:          gocv.NewMat(),
+		_grayImg:          gocv.NewMat(),
+		_lastGrayImg:      gocv.NewMat(),
+		_diffImg:          gocv.NewMat(),
+	}
+}
+
+// Disarm stops the detection, so that the race start is detected at most once per run
+// and a reset through the HTTP API or MQTT does not restart the race on the next frame
+func (r *RaceStartDetector) Disarm() {
+	r.disarmed = true
+}
+
+func (r *RaceStartDetector) Detect(img *gocv.Mat, frameOffset uint64) bool {
+	if r.disarmed {
+		return false
+	}
+
+	switch r.mode {
+	case RaceStartTime:
+		return frameOffset >= r.offsetFrames
+	case RaceStartVisual:
+		return r.detectCue(img)
+	case RaceStartMotion:
+		return r.detectMotion(img)
+	}
+
+	// in "gate" mode the timer starts the race on the first pass through the start gate
+	return false
+}
+
+func (r *RaceStartDetector) Close() {
+	_ = r._cueLowerBoundHSV.Close()
+	_ = r._cueUpperBoundHSV.Close()
+	_ = r._hsvImg.Close()
+	_ = r._cueMask.Close()
+	_ = r._grayImg.Close()
+	_ = r._lastGrayImg.Close()
+	_ = r._diffImg.Close()
+}
+
+func (r *RaceStartDetector) detectCue(img *gocv.Mat) bool {
+	gocv.CvtColor(*img, &r._hsvImg, gocv.ColorBGRToHSV)
+	gocv.InRange(r._hsvImg, r._cueLowerBoundHSV, r._cueUpperBoundHSV, &r._cueMask)
+
+	return gocv.CountNonZero(r._cueMask) >= r.minCueArea
+}
+
+func (r *RaceStartDetector) detectMotion(img *gocv.Mat) bool {
+	gocv.CvtColor(*img, &r._grayImg, gocv.ColorBGRToGray)
+	defer r._grayImg.CopyTo(&r._lastGrayImg)
+
+	if r._lastGrayImg.Empty() {
+		// nothing to compare against on the first frame
+		return false
+	}
+
+	// count the pixels that changed noticeably since the previous frame
+	gocv.AbsDiff(r._grayImg, r._lastGrayImg, &r._diffImg)
+	gocv.Threshold(r._diffImg, &r._diffImg, 25, 255, gocv.ThresholdBinary)
+
+	return gocv.CountNonZero(r._diffImg) >= r.minMotionPixels
+}
@@ -0,0 +1,241 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"html/template"
+	"image/color"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+//go:embed web/report.html
+var reportPageHTML string
+
+var reportPageTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"lapTime": formatLapTime,
+}).Parse(reportPageHTML))
+
+const (
+	chartWidth        = 960
+	lapChartHeight    = 240
+	signalChartHeight = 120
+	// the signal is reduced to at most this many points per gate, keeping the peaks
+	signalChartPoints = 2000
+)
+
+type ReportLap struct {
+	*ExportLap
+	Best bool
+	// one per sector, empty when the lap has no split for the sector
+	Splits []string
+}
+
+type reportPageData struct {
+	Source      string
+	SavedAt     time.Time
+	Created     time.Time
+	Holeshot    int64
+	Statistics  *StatisticsResults
+	Laps        []*ReportLap
+	LapChart    template.HTML
+	SignalChart template.HTML
+	Config      string
+}
+
+// Report writes a self-contained HTML page summarising the session, the caller must hold the timer lock
+func Report(w io.Writer, timer *Timer, session *Session) error {
+	results := NewExportResults(timer, session.Source, "")
+	stats := timer.StatisticsResults(session.Config.Statistics.ConsecutiveLaps)
+
+	data := &reportPageData{
+		Source:     session.Source,
+		SavedAt:    session.SavedAt,
+		Created:    time.Now(),
+		Holeshot:   results.HoleshotMillis,
+		Statistics: stats,
+	}
+
+	for _, lap := range results.Laps {
+		reportLap := &ReportLap{
+			ExportLap: lap,
+			Best:      lap.Number == stats.BestLap,
+		}
+		for _, sector := range stats.Sectors {
+			split := ""
+			for _, lapSplit := range lap.Splits {
+				if lapSplit.Sector == sector.Sector {
+					split = formatLapTime(lapSplit.Millis)
+				}
+			}
+			reportLap.Splits = append(reportLap.Splits, split)
+		}
+		data.Laps = append(data.Laps, reportLap)
+	}
+
+	gateColors := map[string]color.RGBA{}
+	for _, gateConfig := range session.Config.Gates {
+		gateColors[gateConfig.Name] = GateColor2RGBA(gateConfig.Color.LowerBoundHSV, gateConfig.Color.UpperBoundHSV)
+	}
+
+	data.LapChart = lapChart(results.Laps, stats)
+	if session.Signals != nil {
+		data.SignalChart = signalChart(timer, session.Signals, session.Config.Gates, gateColors)
+	}
+
+	config, err := json.MarshalIndent(session.Config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not serialize config. %s", err.Error())
+	}
+	data.Config = string(config)
+
+	if err = reportPageTemplate.Execute(w, data); err != nil {
+		return fmt.Errorf("could not write report. %s", err.Error())
+	}
+	return nil
+}
+
+// lapChart is a bar per lap, with the mean of the valid laps as a line
+func lapChart(laps []*ExportLap, stats *StatisticsResults) template.HTML {
+	if len(laps) == 0 {
+		return ""
+	}
+
+	var maxMillis int64 = 1
+	for _, lap := range laps {
+		if lap.Millis > maxMillis {
+			maxMillis = lap.Millis
+		}
+	}
+
+	top := 20
+	bottom := lapChartHeight - 30
+	y := func(millis int64) int {
+		return bottom - int(millis*int64(bottom-top)/maxMillis)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf(`<svg class="chart" viewBox="0 0 %d %d" xmlns="http://www.w3.org/2000/svg">`, chartWidth, lapChartHeight))
+
+	barWidth := chartWidth / len(laps)
+	for i, lap := range laps {
+		class := "lap"
+		if lap.Status != string(LapValid) {
+			class = "lap invalid"
+		} else if lap.Number == stats.BestLap {
+			class = "lap best"
+		}
+		x := i*barWidth + barWidth/8
+		sb.WriteString(fmt.Sprintf(`<rect class="%s" x="%d" y="%d" width="%d" height="%d"><title>lap %d: %s %s</title></rect>`,
+			class, x, y(lap.Millis), barWidth*3/4, bottom-y(lap.Millis), lap.Number, formatLapTime(lap.Millis), lap.Status))
+		sb.WriteString(fmt.Sprintf(`<text class="label" x="%d" y="%d" text-anchor="middle">%d</text>`, x+barWidth*3/8, lapChartHeight-10, lap.Number))
+	}
+
+	if stats.MeanMillis > 0 {
+		sb.WriteString(fmt.Sprintf(`<line class="mean" x1="0" y1="%d" x2="%d" y2="%d"/>`, y(stats.MeanMillis), chartWidth, y(stats.MeanMillis)))
+		sb.WriteString(fmt.Sprintf(`<text class="label" x="%d" y="%d" text-anchor="end">mean %s</text>`, chartWidth-4, y(stats.MeanMillis)-4, formatLapTime(stats.MeanMillis)))
+	}
+
+	sb.WriteString(`</svg>`)
+	return template.HTML(sb.String())
+}
+
+// signalChart plots the marker area of each gate over the session, with a marker for each of its detections
+func signalChart(timer *Timer, signals *SessionSignals, gates []GateConfig, gateColors map[string]color.RGBA) template.HTML {
+	length := signals.Len()
+	if length == 0 {
+		return ""
+	}
+
+	framesPerPoint := (length + signalChartPoints - 1) / signalChartPoints
+	x := func(frame uint64) float64 {
+		return float64(int64(frame)-int64(signals.FirstFrame)) * chartWidth / float64(length)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf(`<svg class="chart" viewBox="0 0 %d %d" xmlns="http://www.w3.org/2000/svg">`, chartWidth, signalChartHeight*len(gates)))
+
+	for row, gate := range gates {
+		areas := signals.Gates[gate.Name]
+		rgba := gateColors[gate.Name]
+		stroke := fmt.Sprintf("rgb(%d,%d,%d)", rgba.R, rgba.G, rgba.B)
+		top := row * signalChartHeight
+		bottom := top + signalChartHeight - 4
+
+		maxArea := 1
+		for _, area := range areas {
+			if area > maxArea {
+				maxArea = area
+			}
+		}
+
+		var points []string
+		for i := 0; i < len(areas); i += framesPerPoint {
+			peak := 0
+			for j := i; j < i+framesPerPoint && j < len(areas); j++ {
+				if areas[j] > peak {
+					peak = areas[j]
+				}
+			}
+			y := bottom - peak*(signalChartHeight-24)/maxArea
+			points = append(points, fmt.Sprintf("%.1f,%d", x(signals.FirstFrame+uint64(i)), y))
+		}
+
+		sb.WriteString(fmt.Sprintf(`<line class="axis" x1="0" y1="%d" x2="%d" y2="%d"/>`, bottom, chartWidth, bottom))
+		sb.WriteString(fmt.Sprintf(`<text class="label" x="4" y="%d">%s, max area %d</text>`, top+14, html.EscapeString(gate.Name), maxArea))
+		sb.WriteString(fmt.Sprintf(`<polyline class="signal" stroke="%s" points="%s"/>`, stroke, strings.Join(points, " ")))
+
+		for _, detection := range timer.DetectionsByGateName[gate.Name] {
+			sb.WriteString(fmt.Sprintf(`<line class="detection %s" stroke="%s" x1="%.1f" y1="%d" x2="%.1f" y2="%d"><title>detection %d, %s, %s</title></line>`,
+				detection.Origin, stroke, x(detection.FrameOffset), top+18, x(detection.FrameOffset), bottom,
+				detection.ID, html.EscapeString(gate.Name), formatLapTime(timer.Duration(int(detection.FrameOffset)).Milliseconds())))
+		}
+	}
+
+	sb.WriteString(`</svg>`)
+	return template.HTML(sb.String())
+}
+
+func RunReport(arguments []string) error {
+	var sessionPath string
+	var outputPath string
+
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	flags.StringVar(&sessionPath, "session", "", "path to a saved session")
+	flags.StringVar(&outputPath, "out", "", "path to the HTML report (default stdout)")
+	_ = flags.Parse(arguments)
+
+	if sessionPath == "" {
+		return fmt.Errorf("session argument is required")
+	}
+
+	var err error
+	var session *Session
+	if session, err = LoadSession(sessionPath); err != nil {
+		return err
+	}
+
+	var timer *Timer
+	if timer, err = session.NewTimer(); err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if outputPath != "" {
+		if out, err = os.Create(outputPath); err != nil {
+			return fmt.Errorf("could not create report file. %s", err.Error())
+		}
+		defer out.Close()
+	}
+
+	return Report(out, timer, session)
+}
@@ -0,0 +1,236 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+)
+
+type TimerState struct {
+	State          string             `json:"state"`
+	CurrentFrame   uint64             `json:"currentFrame"`
+	CurrentMillis  int64              `json:"currentMillis"`
+	RaceStart      *SessionRaceStart  `json:"raceStart,omitempty"`
+	HoleshotMillis int64              `json:"holeshotMillis,omitempty"`
+	Laps           int                `json:"laps"`
+	LastLap        *SessionLap        `json:"lastLap,omitempty"`
+	LastTransition *SessionTransition `json:"lastTransition,omitempty"`
+	LastDetection  *SessionDetection  `json:"lastDetection,omitempty"`
+}
+
+type ServerError struct {
+	Error string `json:"error"`
+}
+
+// Server is the HTTP API for reading and controlling the running timer
+type Server struct {
+	timer           *Timer
+	consecutiveLaps int
+
+	mux    *http.ServeMux
+	server *http.Server
+}
+
+func NewServer(address string, timer *Timer, consecutiveLaps int) *Server {
+	s := &Server{
+		timer:           timer,
+		consecutiveLaps: consecutiveLaps,
+		mux:             http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/api/state", s.handleState)
+	s.mux.HandleFunc("/api/laps", s.handleLaps)
+	s.mux.HandleFunc("/api/transitions", s.handleTransitions)
+	s.mux.HandleFunc("/api/detections", s.handleDetections)
+	s.mux.HandleFunc("/api/statistics", s.handleStatistics)
+	s.mux.HandleFunc("/api/reset", s.handleCommand(CommandReset))
+	s.mux.HandleFunc("/api/race/start", s.handleCommand(CommandStart))
+	s.mux.HandleFunc("/api/race/stop", s.handleCommand(CommandStop))
+
+	if timer.Events != nil {
+		s.mux.HandleFunc("/events", ServeServerSentEvents(timer.Events))
+		s.mux.HandleFunc("/ws", ServeWebSocketEvents(timer.Events))
+	}
+
+	s.server = &http.Server{
+		Addr:    address,
+		Handler: s.mux,
+	}
+
+	return s
+}
+
+// Handle adds a handler to the server, e.g. for the event stream
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return fmt.Errorf("could not start HTTP server. %s", err.Error())
+	}
+
+	go func() {
+		_ = s.server.Serve(listener)
+	}()
+
+	return nil
+}
+
+func (s *Server) Close() error {
+	if err := s.server.Close(); err != nil {
+		return fmt.Errorf("could not stop HTTP server. %s", err.Error())
+	}
+	return nil
+}
+
+// NewTimerState returns the current race state, the caller must hold the timer lock
+func NewTimerState(timer *Timer) *TimerState {
+	state := &TimerState{
+		State:         timer.State(),
+		CurrentFrame:  timer.CurrentFrame,
+		CurrentMillis: timer.Duration(int(timer.CurrentFrame)).Milliseconds(),
+		Laps:          timer.LapsCount(),
+	}
+
+	if timer.RaceStart != nil {
+		state.RaceStart = NewSessionRaceStart(timer)
+	}
+
+	if timer.Holeshot != nil {
+		state.HoleshotMillis = timer.Duration(timer.Holeshot.Frames()).Milliseconds()
+	}
+
+	if lastLap := timer.LastLap(); lastLap != nil {
+		state.LastLap = NewSessionLap(timer, lastLap)
+	}
+
+	if lastTransition := timer.LastTransition(); lastTransition != nil {
+		state.LastTransition = NewSessionTransition(timer, lastTransition)
+	}
+
+	if lastDetection := timer.LastDetection(); lastDetection != nil {
+		state.LastDetection = NewSessionDetection(timer, lastDetection)
+	}
+
+	return state
+}
+
+func (s *Server) handleState(w http.ResponseWriter, r *http.Request) {
+	s.read(w, r, func() interface{} {
+		return NewTimerState(s.timer)
+	})
+}
+
+func (s *Server) handleLaps(w http.ResponseWriter, r *http.Request) {
+	s.read(w, r, func() interface{} {
+		return NewSessionLaps(s.timer)
+	})
+}
+
+func (s *Server) handleTransitions(w http.ResponseWriter, r *http.Request) {
+	s.read(w, r, func() interface{} {
+		return NewSessionTransitions(s.timer)
+	})
+}
+
+func (s *Server) handleDetections(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		// manual detection
+		s.handleCommand(CommandDetection)(w, r)
+		return
+	}
+
+	s.read(w, r, func() interface{} {
+		return NewSessionDetections(s.timer)
+	})
+}
+
+func (s *Server) handleStatistics(w http.ResponseWriter, r *http.Request) {
+	s.read(w, r, func() interface{} {
+		return s.timer.StatisticsResults(s.consecutiveLaps)
+	})
+}
+
+func (s *Server) handleCommand(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			s.write(w, http.StatusMethodNotAllowed, &ServerError{Error: "method not allowed"})
+			return
+		}
+
+		// a web page of another site can only send a JSON request after a CORS preflight, which the server never allows.
+		// Without this, any page open in the browser could reset the race with a plain form POST.
+		if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+			s.write(w, http.StatusUnsupportedMediaType, &ServerError{Error: "control requests must be sent as application/json"})
+			return
+		}
+
+		command := &Command{}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(command); err != nil {
+				s.write(w, http.StatusBadRequest, &ServerError{Error: fmt.Sprintf("invalid command. %s", err.Error())})
+				return
+			}
+		}
+		command.Name = name
+		if command.Author == "" {
+			command.Author = r.RemoteAddr
+		}
+
+		var err error
+		var result interface{}
+		s.timer.withLock(func() {
+			var detection *Detection
+			detection, err = s.timer.Execute(command)
+			result = NewTimerState(s.timer)
+			if detection != nil {
+				result = NewSessionDetection(s.timer, detection)
+			}
+		})
+
+		if err != nil {
+			status := http.StatusConflict
+			var invalid *InvalidCommandError
+			if errors.As(err, &invalid) {
+				status = http.StatusBadRequest
+			}
+			s.write(w, status, &ServerError{Error: err.Error()})
+			return
+		}
+
+		s.write(w, http.StatusOK, result)
+	}
+}
+
+// read builds the response while holding the timer lock
+func (s *Server) read(w http.ResponseWriter, r *http.Request, response func() interface{}) {
+	if r.Method != http.MethodGet {
+		s.write(w, http.StatusMethodNotAllowed, &ServerError{Error: "method not allowed"})
+		return
+	}
+
+	var result interface{}
+	s.timer.withLock(func() {
+		result = response()
+	})
+
+	s.write(w, http.StatusOK, result)
+}
+
+func (s *Server) write(w http.ResponseWriter, status int, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(result)
+}
@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServerStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"state", http.MethodGet, "/api/state", "", http.StatusOK},
+		{"laps", http.MethodGet, "/api/laps", "", http.StatusOK},
+		{"manual detection", http.MethodPost, "/api/detections", `{"gate": "a", "frameOffset": 2400}`, http.StatusOK},
+		{"stop", http.MethodPost, "/api/race/stop", "", http.StatusOK},
+		{"unknown gate", http.MethodPost, "/api/detections", `{"gate": "c"}`, http.StatusBadRequest},
+		{"invalid body", http.MethodPost, "/api/detections", `{"gate":`, http.StatusBadRequest},
+		{"already started", http.MethodPost, "/api/race/start", "", http.StatusConflict},
+		{"read only", http.MethodPost, "/api/laps", "", http.StatusMethodNotAllowed},
+		{"control only", http.MethodGet, "/api/reset", "", http.StatusMethodNotAllowed},
+		{"unknown path", http.MethodGet, "/api/pilots", "", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			timer := testLapsTimer()
+			timer.CurrentFrame = 5000
+			server := NewServer("127.0.0.1:0", timer, 3)
+
+			request := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			request.Header.Set("Content-Type", "application/json")
+			response := httptest.NewRecorder()
+			server.mux.ServeHTTP(response, request)
+
+			if response.Code != test.status {
+				t.Errorf("got status %d (%s), want %d", response.Code, strings.TrimSpace(response.Body.String()), test.status)
+			}
+		})
+	}
+}
+
+func TestServerStopFinished(t *testing.T) {
+	timer := testLapsTimer()
+	server := NewServer("127.0.0.1:0", timer, 3)
+
+	for _, status := range []int{http.StatusOK, http.StatusConflict} {
+		request := httptest.NewRequest(http.MethodPost, "/api/race/stop", nil)
+		request.Header.Set("Content-Type", "application/json; charset=utf-8")
+		response := httptest.NewRecorder()
+		server.mux.ServeHTTP(response, request)
+		if response.Code != status {
+			t.Errorf("got status %d, want %d", response.Code, status)
+		}
+	}
+}
+
+func TestServerControlContentType(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"form post", "application/x-www-form-urlencoded", "command=reset"},
+		{"plain text", "text/plain", `{"reason": "no preflight"}`},
+		{"none", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			timer := testLapsTimer()
+			server := NewServer("127.0.0.1:0", timer, 3)
+
+			request := httptest.NewRequest(http.MethodPost, "/api/reset", strings.NewReader(test.body))
+			if test.contentType != "" {
+				request.Header.Set("Content-Type", test.contentType)
+			}
+			response := httptest.NewRecorder()
+			server.mux.ServeHTTP(response, request)
+
+			if response.Code != http.StatusUnsupportedMediaType {
+				t.Errorf("got status %d, want %d", response.Code, http.StatusUnsupportedMediaType)
+			}
+			if len(timer.Laps) == 0 || response.Header().Get("Access-Control-Allow-Origin") != "" {
+				t.Errorf("got the race reset or a CORS header, want neither")
+			}
+		})
+	}
+}
@@ -0,0 +1,352 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// SessionVersion is incremented whenever the session document changes in a way older readers cannot handle
+const SessionVersion = 1
+
+type SessionRaceStart struct {
+	FrameOffset uint64 `json:"frameOffset"`
+	TimeMillis  int64  `json:"timeMillis"`
+	Source      string `json:"source"`
+}
+
+type SessionDetection struct {
+	ID          int    `json:"id"`
+	Gate        string `json:"gate"`
+	FrameOffset uint64 `json:"frameOffset"`
+	TimeMillis  int64  `json:"timeMillis"`
+	Origin      string `json:"origin"`
+	// only for detector detections
+	Activation *Activation `json:"activation,omitempty"`
+	// only for playlist sessions, the video the detection is in, and its frame and time in that video
+	SourcePath   string `json:"sourcePath,omitempty"`
+	SourceFrame  uint64 `json:"sourceFrame,omitempty"`
+	SourceMillis int64  `json:"sourceMillis,omitempty"`
+}
+
+type SessionLap struct {
+	Number           int    `json:"number"`
+	Gate             string `json:"gate"`
+	StartFrame       uint64 `json:"startFrame"`
+	StopFrame        uint64 `json:"stopFrame"`
+	StartDetectionID int    `json:"startDetectionId,omitempty"`
+	StopDetectionID  int    `json:"stopDetectionId,omitempty"`
+	Millis           int64  `json:"millis"`
+	Status           string `json:"status"`
+	Reason           string `json:"reason,omitempty"`
+	Inferred         bool   `json:"inferred,omitempty"`
+}
+
+type SessionTransition struct {
+	Sector           string `json:"sector"`
+	StartGate        string `json:"startGate"`
+	StopGate         string `json:"stopGate"`
+	StartDetectionID int    `json:"startDetectionId"`
+	StopDetectionID  int    `json:"stopDetectionId"`
+	StartFrame       uint64 `json:"startFrame"`
+	StopFrame        uint64 `json:"stopFrame"`
+	Millis           int64  `json:"millis"`
+}
+
+type SessionCorrection struct {
+	Time        time.Time `json:"time"`
+	Author      string    `json:"author"`
+	Action      string    `json:"action"`
+	DetectionID int       `json:"detectionId"`
+	Gate        string    `json:"gate"`
+	FromFrame   uint64    `json:"fromFrame,omitempty"`
+	ToFrame     uint64    `json:"toFrame,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+}
+
+// Session is the JSON document a timing session is saved as
+type Session struct {
+	Version      int                  `json:"version"`
+	SavedAt      time.Time            `json:"savedAt"`
+	Source       string               `json:"source"`
+	FramesPerSec int                  `json:"framesPerSec"`
+	FrameCount   uint64               `json:"frameCount"`
+	Config       *Config              `json:"config"`
+	RaceStart    *SessionRaceStart    `json:"raceStart,omitempty"`
+	Holeshot     int64                `json:"holeshotMillis,omitempty"`
+	Finished     bool                 `json:"finished"`
+	FinishFrame  uint64               `json:"finishFrame,omitempty"`
+	Detections   []*SessionDetection  `json:"detections"`
+	Laps         []*SessionLap        `json:"laps"`
+	Transitions  []*SessionTransition `json:"transitions"`
+	Corrections  []*SessionCorrection `json:"corrections"`
+	// only when the session was timed from a video
+	Signals *SessionSignals `json:"signals,omitempty"`
+	// only when the session was timed from several videos, the source is the first of them
+	Playlist []*PlaylistFile `json:"playlist,omitempty"`
+	// only when the session continued a saved session, the frame offset the source starts at
+	SourceFirstFrame uint64 `json:"sourceFirstFrame,omitempty"`
+}
+
+func NewSession(timer *Timer, config *Config, source string, frameCount uint64) *Session {
+	// credentials are not part of the snapshot, nor are the hooks, whose urls and commands may hold tokens
+	snapshot := *config
+	snapshot.MQTT.Username = ""
+	snapshot.MQTT.Password = ""
+	snapshot.Hooks = nil
+
+	session := &Session{
+		Version:      SessionVersion,
+		SavedAt:      time.Now(),
+		Source:       source,
+		FramesPerSec: timer.framesPerSec,
+		FrameCount:   frameCount,
+		Config:       &snapshot,
+		Finished:     timer.Finished,
+		FinishFrame:  timer.finishFrame,
+		Corrections:  []*SessionCorrection{},
+	}
+
+	if timer.RaceStart != nil {
+		session.RaceStart = NewSessionRaceStart(timer)
+	}
+
+	if timer.Holeshot != nil {
+		session.Holeshot = timer.Duration(timer.Holeshot.Frames()).Milliseconds()
+	}
+
+	if timer.Playlist != nil {
+		session.Playlist = timer.Playlist.Files
+	}
+
+	session.Detections = NewSessionDetections(timer)
+	session.Laps = NewSessionLaps(timer)
+	session.Transitions = NewSessionTransitions(timer)
+
+	for _, correction := range timer.Corrections {
+		session.Corrections = append(session.Corrections, &SessionCorrection{
+			Time:        correction.Time,
+			Author:      correction.Author,
+			Action:      correction.Action,
+			DetectionID: correction.DetectionID,
+			Gate:        correction.Gate,
+			FromFrame:   correction.FromFrame,
+			ToFrame:     correction.ToFrame,
+			Reason:      correction.Reason,
+		})
+	}
+
+	return session
+}
+
+func NewSessionDetection(timer *Timer, detection *Detection) *SessionDetection {
+	sessionDetection := &SessionDetection{
+		ID:          detection.ID,
+		Gate:        detection.Gate.Name,
+		FrameOffset: detection.FrameOffset,
+		TimeMillis:  timer.Duration(int(detection.FrameOffset)).Milliseconds(),
+		Origin:      detection.Origin,
+		Activation:  detection.Activation,
+	}
+	if timer.Playlist != nil {
+		if file, frame := timer.Playlist.FileAt(detection.FrameOffset); file != nil {
+			sessionDetection.SourcePath = file.Path
+			sessionDetection.SourceFrame = frame
+			sessionDetection.SourceMillis = timer.Duration(int(frame)).Milliseconds()
+		}
+	}
+	return sessionDetection
+}
+
+func NewSessionDetections(timer *Timer) []*SessionDetection {
+	detections := make([]*SessionDetection, 0, len(timer.DetectionsInOrder))
+	for _, detection := range timer.DetectionsInOrder {
+		detections = append(detections, NewSessionDetection(timer, detection))
+	}
+	return detections
+}
+
+func NewSessionRaceStart(timer *Timer) *SessionRaceStart {
+	return &SessionRaceStart{
+		FrameOffset: timer.RaceStart.FrameOffset,
+		TimeMillis:  timer.Duration(int(timer.RaceStart.FrameOffset)).Milliseconds(),
+		Source:      timer.RaceStart.Source,
+	}
+}
+
+func NewSessionLap(timer *Timer, lap *Lap) *SessionLap {
+	sessionLap := &SessionLap{
+		Number:     timer.LapNumber(lap),
+		Gate:       lap.Gate().Name,
+		StartFrame: lap.startFrame,
+		StopFrame:  lap.stopFrame,
+		Millis:     timer.Duration(lap.Frames()).Milliseconds(),
+		Status:     string(lap.Status),
+		Reason:     lap.Reason,
+		Inferred:   lap.HasInferredCrossing(),
+	}
+	if lap.start != nil {
+		sessionLap.StartDetectionID = lap.start.ID
+	}
+	if lap.stop != nil {
+		sessionLap.StopDetectionID = lap.stop.ID
+	}
+	return sessionLap
+}
+
+func NewSessionLaps(timer *Timer) []*SessionLap {
+	laps := make([]*SessionLap, 0, len(timer.Laps))
+	for _, lap := range timer.Laps {
+		laps = append(laps, NewSessionLap(timer, lap))
+	}
+	return laps
+}
+
+func NewSessionTransition(timer *Timer, transition *Transition) *SessionTransition {
+	return &SessionTransition{
+		Sector:           transition.Name(),
+		StartGate:        transition.start.Gate.Name,
+		StopGate:         transition.stop.Gate.Name,
+		StartDetectionID: transition.start.ID,
+		StopDetectionID:  transition.stop.ID,
+		StartFrame:       transition.start.FrameOffset,
+		StopFrame:        transition.stop.FrameOffset,
+		Millis:           timer.Duration(transition.Frames()).Milliseconds(),
+	}
+}
+
+func NewSessionTransitions(timer *Timer) []*SessionTransition {
+	transitions := make([]*SessionTransition, 0, len(timer.Transitions))
+	for _, transition := range timer.Transitions {
+		transitions = append(transitions, NewSessionTransition(timer, transition))
+	}
+	return transitions
+}
+
+func LoadSession(path string) (*Session, error) {
+	var err error
+	var sessionJson []byte
+
+	if sessionJson, err = os.ReadFile(path); err != nil {
+		return nil, fmt.Errorf("could not read session file. %s", err.Error())
+	}
+
+	// the config is parsed on its own, over the defaults of the settings added after the session was saved
+	var document struct {
+		Session
+		Config json.RawMessage `json:"config"`
+	}
+	if err = json.Unmarshal(sessionJson, &document); err != nil {
+		return nil, fmt.Errorf("could not parse session file. %s", err.Error())
+	}
+	session := document.Session
+
+	if session.Version < 1 || session.Version > SessionVersion {
+		return nil, fmt.Errorf("unsupported session file version %d", session.Version)
+	}
+
+	if len(document.Config) == 0 || string(document.Config) == "null" {
+		return nil, fmt.Errorf("session file has no config")
+	}
+
+	if session.Config, err = parseConfigDefaults(document.Config); err != nil {
+		return nil, fmt.Errorf("invalid session config. %s", err.Error())
+	}
+	if err = session.Config.ValidateSession(); err != nil {
+		return nil, fmt.Errorf("invalid session config. %s", err.Error())
+	}
+
+	return &session, nil
+}
+
+func (s *Session) Save(path string) error {
+	var err error
+	var sessionJson []byte
+
+	if sessionJson, err = json.MarshalIndent(s, "", "  "); err != nil {
+		return fmt.Errorf("could not serialize session. %s", err.Error())
+	}
+
+	if err = os.WriteFile(path, sessionJson, 0644); err != nil {
+		return fmt.Errorf("could not write session file. %s", err.Error())
+	}
+
+	return nil
+}
+
+// NewTimer creates a timer for reviewing the session, without the video
+func (s *Session) NewTimer() (*Timer, error) {
+	var gates []*Gate
+	for _, gateConfig := range s.Config.Gates {
+		gates = append(gates, &Gate{Name: gateConfig.Name})
+	}
+
+	timer := NewTimerFromConfig(s.Config, gates)
+	if err := s.Restore(timer); err != nil {
+		return nil, err
+	}
+	return timer, nil
+}
+
+// Restore loads the session detections and corrections into the timer, and recomputes laps and transitions
+func (s *Session) Restore(timer *Timer) error {
+	detections := make([]*Detection, 0, len(s.Detections))
+	for _, sessionDetection := range s.Detections {
+		gate, ok := timer.GatesByName[sessionDetection.Gate]
+		if !ok {
+			return fmt.Errorf("session detection %d has unknown gate %s", sessionDetection.ID, sessionDetection.Gate)
+		}
+
+		detections = append(detections, &Detection{
+			ID:          sessionDetection.ID,
+			Gate:        gate,
+			FrameOffset: sessionDetection.FrameOffset,
+			Origin:      sessionDetection.Origin,
+			Activation:  sessionDetection.Activation,
+		})
+
+		if sessionDetection.ID > timer.lastDetectionID {
+			timer.lastDetectionID = sessionDetection.ID
+		}
+	}
+
+	corrections := make([]*Correction, 0, len(s.Corrections))
+	for _, sessionCorrection := range s.Corrections {
+		corrections = append(corrections, &Correction{
+			Time:        sessionCorrection.Time,
+			Author:      sessionCorrection.Author,
+			Action:      sessionCorrection.Action,
+			DetectionID: sessionCorrection.DetectionID,
+			Gate:        sessionCorrection.Gate,
+			FromFrame:   sessionCorrection.FromFrame,
+			ToFrame:     sessionCorrection.ToFrame,
+			Reason:      sessionCorrection.Reason,
+		})
+	}
+
+	timer.RaceStart = nil
+	if s.RaceStart != nil {
+		timer.RaceStart = &RaceStart{
+			FrameOffset: s.RaceStart.FrameOffset,
+			Source:      s.RaceStart.Source,
+		}
+	}
+
+	timer.Playlist = nil
+	if len(s.Playlist) > 0 {
+		timer.Playlist = &Playlist{Files: s.Playlist}
+	}
+
+	timer.DetectionsInOrder = detections
+	timer.Corrections = corrections
+	timer.Finished = s.Finished
+	timer.finishFrame = s.FinishFrame
+	timer.rebuild()
+
+	return nil
+}
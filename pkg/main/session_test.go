@@ -0,0 +1,166 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testSessionConfig is the config of testLapsTimer
+func testSessionConfig() *Config {
+	config := DefaultConfig()
+	config.FramesPerSec = 100
+	config.Track.Sequence = []string{"start", "a"}
+	for _, name := range []string{"start", "a"} {
+		config.Gates = append(config.Gates, GateConfig{
+			Name:  name,
+			Color: GateColorConfig{LowerBoundHSV: []int{0, 100, 100}, UpperBoundHSV: []int{10, 255, 255}},
+		})
+	}
+	return config
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	timer := testLapsTimer()
+	if _, err := timer.InsertDetection("a", 2400, "director", "missed by the detector"); err != nil {
+		t.Fatal(err)
+	}
+	if err := timer.RemoveDetection(4, "director", "false detection"); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "session.json")
+	saved := NewSession(timer, testSessionConfig(), "DVR0002.ts", 5000)
+	// continued from a session of 2000 frames
+	saved.SourceFirstFrame = 2000
+	if err := saved.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := LoadSession(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Source != "DVR0002.ts" || session.SourceFirstFrame != 2000 || session.FrameCount != 5000 {
+		t.Errorf("got source %s from frame %d with %d frames, want DVR0002.ts from frame 2000 with 5000 frames", session.Source, session.SourceFirstFrame, session.FrameCount)
+	}
+
+	restored, err := session.NewTimer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(NewSessionDetections(restored), NewSessionDetections(timer)) {
+		t.Errorf("got detections %+v, want %+v", NewSessionDetections(restored), NewSessionDetections(timer))
+	}
+	if !reflect.DeepEqual(NewSessionLaps(restored), NewSessionLaps(timer)) {
+		t.Errorf("got laps %+v, want %+v", NewSessionLaps(restored), NewSessionLaps(timer))
+	}
+	if !reflect.DeepEqual(NewSessionTransitions(restored), NewSessionTransitions(timer)) {
+		t.Errorf("got transitions %+v, want %+v", NewSessionTransitions(restored), NewSessionTransitions(timer))
+	}
+	if restored.RaceStart == nil || restored.RaceStart.FrameOffset != 0 || restored.RaceStart.Source != RaceStartGate {
+		t.Errorf("got race start %+v, want the first start gate pass", restored.RaceStart)
+	}
+
+	if len(restored.Corrections) != 2 {
+		t.Fatalf("got %d corrections, want 2", len(restored.Corrections))
+	}
+	for i, correction := range restored.Corrections {
+		want := timer.Corrections[i]
+		if correction.Action != want.Action || correction.DetectionID != want.DetectionID || correction.Author != want.Author || !correction.Time.Equal(want.Time) {
+			t.Errorf("got correction %v, want %v", correction, want)
+		}
+	}
+
+	// new detections continue after the restored ids
+	detection, _ := restored.InsertDetection("start", 4900, "director", "")
+	if detection.ID != timer.lastDetectionID+1 {
+		t.Errorf("got detection id %d, want %d", detection.ID, timer.lastDetectionID+1)
+	}
+}
+
+func TestSessionRedactsCredentials(t *testing.T) {
+	config := testSessionConfig()
+	config.MQTT.Username = "timer"
+	config.MQTT.Password = "secret"
+	config.Hooks = []HookConfig{{URL: "https://example.com/hook?token=secret", TimeoutMillis: 1000}}
+
+	session := NewSession(testLapsTimer(), config, "DVR0001.ts", 5000)
+
+	if session.Config.MQTT.Username != "" || session.Config.MQTT.Password != "" || len(session.Config.Hooks) != 0 {
+		t.Errorf("got mqtt %+v and hooks %+v in the snapshot, want none", session.Config.MQTT, session.Config.Hooks)
+	}
+	// the running config is left as it is
+	if config.MQTT.Password != "secret" || len(config.Hooks) != 1 {
+		t.Errorf("got config mqtt %+v and hooks %+v, want them unchanged", config.MQTT, config.Hooks)
+	}
+}
+
+func TestLoadSessionConfigDefaults(t *testing.T) {
+	// a session saved before the log and overlay settings were added
+	path := filepath.Join(t.TempDir(), "session.json")
+	document := `{"version": 1, "source": "DVR0001.ts", "framesPerSec": 100, "config": {"framesPerSec": 100}, "detections": []}`
+	if err := os.WriteFile(path, []byte(document), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := LoadSession(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Config.Log.Level != "info" || session.Config.Overlay.Color != "#ffffff" || len(session.Config.Overlay.Elements) == 0 {
+		t.Errorf("got config %+v, want the defaults", session.Config)
+	}
+}
+
+func TestLoadSessionCaptureSettings(t *testing.T) {
+	// a session saved before the visual race start needed the cue bounds, these settings are not used to review it
+	path := filepath.Join(t.TempDir(), "session.json")
+	document := `{"version": 1, "source": "DVR0001.ts", "framesPerSec": 100, "config": {"framesPerSec": 100, "race": {"start": {"mode": "visual"}}}, "detections": []}`
+	if err := os.WriteFile(path, []byte(document), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := LoadSession(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Config.Race.Start.Mode != RaceStartVisual {
+		t.Errorf("got race start mode %s, want %s", session.Config.Race.Start.Mode, RaceStartVisual)
+	}
+
+	// but they are needed to time more video with it
+	if err = session.Config.Validate(); err == nil {
+		t.Errorf("got no error for the visual race start without cue bounds")
+	}
+}
+
+func TestLoadSessionErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+	}{
+		{"newer version", `{"version": 99, "config": {}}`},
+		{"no config", `{"version": 1}`},
+		{"invalid config", `{"version": 1, "config": {"track": {"sequence": ["start"]}}}`},
+		{"not json", `session`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "session.json")
+			if err := os.WriteFile(path, []byte(test.document), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadSession(path); err == nil {
+				t.Errorf("got no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+// SessionSignals are the marker areas of each gate in every processed frame,
+// so that the detection signal can be plotted without the video
+type SessionSignals struct {
+	// frame offset of the first area
+	FirstFrame uint64           `json:"firstFrame"`
+	Gates      map[string][]int `json:"gates"`
+}
+
+func NewSessionSignals() *SessionSignals {
+	return &SessionSignals{
+		Gates: map[string][]int{},
+	}
+}
+
+// Record adds the gate areas of the frame, frames that were skipped are recorded as 0
+func (s *SessionSignals) Record(frame uint64, detector *Detector, gates []*Gate) {
+	length := s.Len()
+	if length == 0 {
+		s.FirstFrame = frame
+	}
+
+	if frame < s.FirstFrame+uint64(length) {
+		// already recorded
+		return
+	}
+
+	// skipped frames, and gates that were added to the config later, are padded with zeroes
+	length = int(frame - s.FirstFrame)
+	for _, gate := range gates {
+		areas := s.Gates[gate.Name]
+		for len(areas) < length {
+			areas = append(areas, 0)
+		}
+		s.Gates[gate.Name] = append(areas, detector.GateArea(gate))
+	}
+}
+
+// Len is the number of recorded frames
+func (s *SessionSignals) Len() int {
+	length := 0
+	for _, areas := range s.Gates {
+		if len(areas) > length {
+			length = len(areas)
+		}
+	}
+	return length
+}
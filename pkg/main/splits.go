@@ -0,0 +1,162 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Sector is a gate-to-gate leg of the course.
+//
+// The key is the transition name, with a suffix when the same leg is flown
+// more than once per lap (e.g. figure-8 layouts): "pink->green", "pink->green#2"
+type Sector struct {
+	Key  string
+	Best *Transition
+}
+
+type LapSplits struct {
+	Lap    *Lap
+	Keys   []string
+	Splits []*Transition
+}
+
+func (l *LapSplits) Sequence() string {
+	return strings.Join(l.Keys, ",")
+}
+
+func (l *LapSplits) Split(key string) *Transition {
+	for i, k := range l.Keys {
+		if k == key {
+			return l.Splits[i]
+		}
+	}
+	return nil
+}
+
+func (t *Timer) LapSplits(lap *Lap) *LapSplits {
+	splits := &LapSplits{
+		Lap:    lap,
+		Keys:   []string{},
+		Splits: []*Transition{},
+	}
+
+	occurrences := map[string]int{}
+	for _, transition := range t.Transitions {
+		if transition.start.FrameOffset < lap.startFrame || transition.stop.FrameOffset > lap.stopFrame {
+			continue
+		}
+
+		name := transition.Name()
+		occurrences[name] += 1
+		key := name
+		if occurrences[name] > 1 {
+			key = fmt.Sprintf("%s#%d", name, occurrences[name])
+		}
+
+		splits.Keys = append(splits.Keys, key)
+		splits.Splits = append(splits.Splits, transition)
+	}
+
+	return splits
+}
+
+func (t *Timer) SplitTable() []*LapSplits {
+	table := make([]*LapSplits, 0, len(t.Laps))
+	for _, lap := range t.Laps {
+		table = append(table, t.LapSplits(lap))
+	}
+	return table
+}
+
+// Sectors returns the best time for each sector of the course.
+//
+// The course is the sector sequence flown in most valid laps, so that laps with missed
+// or extra detections do not add sectors that are not part of the track.
+func (t *Timer) Sectors() []*Sector {
+	var table []*LapSplits
+	for _, lap := range t.ValidLaps() {
+		table = append(table, t.LapSplits(lap))
+	}
+
+	counts := map[string]int{}
+	var course *LapSplits
+	for _, splits := range table {
+		counts[splits.Sequence()] += 1
+		if course == nil || counts[splits.Sequence()] > counts[course.Sequence()] {
+			course = splits
+		}
+	}
+
+	if course == nil {
+		return []*Sector{}
+	}
+
+	sectors := make([]*Sector, 0, len(course.Keys))
+	for _, key := range course.Keys {
+		sector := &Sector{Key: key}
+		for _, splits := range table {
+			if split := splits.Split(key); split != nil {
+				if sector.Best == nil || split.Frames() < sector.Best.Frames() {
+					sector.Best = split
+				}
+			}
+		}
+		sectors = append(sectors, sector)
+	}
+
+	return sectors
+}
+
+// TheoreticalBest is the sum of the best sector times, in frames
+func (t *Timer) TheoreticalBest() (int, bool) {
+	sectors := t.Sectors()
+	if len(sectors) == 0 {
+		return 0, false
+	}
+
+	frames := 0
+	for _, sector := range sectors {
+		frames += sector.Best.Frames()
+	}
+	return frames, true
+}
+
+func (t *Timer) SplitsSummary() string {
+	sectors := t.Sectors()
+	if len(sectors) == 0 {
+		return "Splits: none"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Lap")
+	for _, sector := range sectors {
+		sb.WriteString(fmt.Sprintf("\t%s", sector.Key))
+	}
+	sb.WriteString("\n")
+
+	for i, splits := range t.SplitTable() {
+		sb.WriteString(fmt.Sprintf("%d", i+1))
+		for _, sector := range sectors {
+			if split := splits.Split(sector.Key); split != nil {
+				sb.WriteString(fmt.Sprintf("\t%v", t.Duration(split.Frames())))
+			} else {
+				sb.WriteString("\t-")
+			}
+		}
+		sb.WriteString("\n")
+	}
+
+	sb.WriteString("Best")
+	for _, sector := range sectors {
+		sb.WriteString(fmt.Sprintf("\t%v", t.Duration(sector.Best.Frames())))
+	}
+	sb.WriteString("\n")
+
+	theoreticalBest, _ := t.TheoreticalBest()
+	sb.WriteString(fmt.Sprintf("Theoretical best: %v", t.Duration(theoreticalBest)))
+	return sb.String()
+}
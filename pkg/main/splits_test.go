@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSectors(t *testing.T) {
+	// the sectors of the valid laps are start->a 500, 400, 500, 300 and a->start 500, 400, 400, 500 frames
+	timer := testLapsTimer()
+
+	sectors := timer.Sectors()
+
+	var keys []string
+	var best []int
+	for _, sector := range sectors {
+		keys = append(keys, sector.Key)
+		best = append(best, sector.Best.Frames())
+	}
+	if !reflect.DeepEqual(keys, []string{"start->a", "a->start"}) {
+		t.Errorf("sectors: got %v, want [start->a a->start]", keys)
+	}
+	if !reflect.DeepEqual(best, []int{300, 400}) {
+		t.Errorf("best sectors: got %v, want [300 400]", best)
+	}
+
+	frames, ok := timer.TheoreticalBest()
+	if !ok || frames != 700 {
+		t.Errorf("theoretical best: got %d (%v), want 700", frames, ok)
+	}
+}
+
+func TestSectorsWithoutLaps(t *testing.T) {
+	timer := testTimer(nil, []string{"start", "a"}, testPass{"start", 0}, testPass{"a", 500})
+
+	if sectors := timer.Sectors(); len(sectors) != 0 {
+		t.Errorf("got %d sectors, want none", len(sectors))
+	}
+	if _, ok := timer.TheoreticalBest(); ok {
+		t.Errorf("got a theoretical best without laps")
+	}
+}
+
+func TestLapSplitsRepeatedSector(t *testing.T) {
+	// a figure-8 flies a->b twice per lap
+	timer := testTimer(Track{"start", "a", "b", "a", "b"}, []string{"start", "a", "b"},
+		testPass{"start", 0}, testPass{"a", 100}, testPass{"b", 250}, testPass{"a", 400}, testPass{"b", 500}, testPass{"start", 700})
+
+	if len(timer.Laps) != 1 || !timer.Laps[0].IsValid() {
+		t.Fatalf("got %d laps, want 1 valid lap", len(timer.Laps))
+	}
+
+	splits := timer.LapSplits(timer.Laps[0])
+
+	want := "start->a,a->b,b->a,a->b#2,b->start"
+	if splits.Sequence() != want {
+		t.Errorf("got sequence %s, want %s", splits.Sequence(), want)
+	}
+	if split := splits.Split("a->b#2"); split == nil || split.Frames() != 100 {
+		t.Errorf("got the second a->b split %v, want 100 frames", split)
+	}
+
+	frames, _ := timer.TheoreticalBest()
+	if frames != 700 {
+		t.Errorf("theoretical best: got %d, want the lap time 700", frames)
+	}
+}
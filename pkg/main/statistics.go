@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// LapStatistics are computed from the valid laps only
+type LapStatistics struct {
+	Count  int
+	Best   *Lap
+	Worst  *Lap
+	Mean   time.Duration
+	Median time.Duration
+	StdDev time.Duration
+
+	// best run of ConsecutiveLaps laps in a row (e.g. 3 for MultiGP)
+	ConsecutiveLaps     int
+	BestConsecutive     []*Lap
+	BestConsecutiveTime time.Duration
+}
+
+func (t *Timer) Statistics(consecutiveLaps int) *LapStatistics {
+	laps := t.ValidLaps()
+	stats := &LapStatistics{
+		Count:           len(laps),
+		ConsecutiveLaps: consecutiveLaps,
+	}
+
+	if len(laps) == 0 {
+		return stats
+	}
+
+	frames := make([]int, 0, len(laps))
+	sum := 0
+	for _, lap := range laps {
+		if stats.Best == nil || lap.Frames() < stats.Best.Frames() {
+			stats.Best = lap
+		}
+		if stats.Worst == nil || lap.Frames() > stats.Worst.Frames() {
+			stats.Worst = lap
+		}
+		frames = append(frames, lap.Frames())
+		sum += lap.Frames()
+	}
+
+	mean := float64(sum) / float64(len(frames))
+	variance := 0.0
+	for _, f := range frames {
+		variance += (float64(f) - mean) * (float64(f) - mean)
+	}
+	variance /= float64(len(frames))
+
+	stats.Mean = t.fractionalDuration(mean)
+	stats.Median = t.fractionalDuration(median(frames))
+	stats.StdDev = t.fractionalDuration(math.Sqrt(variance))
+
+	// sliding window over the laps in the order they were flown, an invalid lap breaks the run
+	if consecutiveLaps > 0 && len(t.Laps) >= consecutiveLaps {
+		bestSum := -1
+		for i := 0; i+consecutiveLaps <= len(t.Laps); i++ {
+			windowSum := 0
+			for _, lap := range t.Laps[i : i+consecutiveLaps] {
+				if !lap.IsValid() {
+					windowSum = -1
+					break
+				}
+				windowSum += lap.Frames()
+			}
+			if windowSum == -1 {
+				continue
+			}
+			if bestSum == -1 || windowSum < bestSum {
+				bestSum = windowSum
+				stats.BestConsecutive = t.Laps[i : i+consecutiveLaps]
+			}
+		}
+		if bestSum != -1 {
+			stats.BestConsecutiveTime = t.Duration(bestSum)
+		}
+	}
+
+	return stats
+}
+
+func (t *Timer) fractionalDuration(frames float64) time.Duration {
+	return time.Duration(frames * float64(time.Second) / float64(t.framesPerSec))
+}
+
+func (t *Timer) LapNumber(lap *Lap) int {
+	for i, l := range t.Laps {
+		if l == lap {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (t *Timer) StatisticsSummary(stats *LapStatistics) string {
+	var sb strings.Builder
+	for i, lap := range t.Laps {
+		if !lap.IsValid() {
+			sb.WriteString(fmt.Sprintf("Lap %d: %v, %s (%s)\n", i+1, t.Duration(lap.Frames()), lap.Status, lap.Reason))
+		}
+	}
+
+	if stats.Count == 0 {
+		sb.WriteString("Laps: 0")
+		return sb.String()
+	}
+
+	sb.WriteString(fmt.Sprintf("Laps: %d\n", stats.Count))
+	sb.WriteString(fmt.Sprintf("Best lap: %v (lap %d)\n", t.Duration(stats.Best.Frames()), t.LapNumber(stats.Best)))
+	sb.WriteString(fmt.Sprintf("Worst lap: %v (lap %d)\n", t.Duration(stats.Worst.Frames()), t.LapNumber(stats.Worst)))
+	sb.WriteString(fmt.Sprintf("Mean: %v, Median: %v, Std dev: %v\n", stats.Mean, stats.Median, stats.StdDev))
+	if len(stats.BestConsecutive) > 0 {
+		first := t.LapNumber(stats.BestConsecutive[0])
+		sb.WriteString(fmt.Sprintf("Best %d consecutive: %v (laps %d-%d)\n", stats.ConsecutiveLaps, stats.BestConsecutiveTime, first, first+stats.ConsecutiveLaps-1))
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+type SectorResults struct {
+	Sector     string `json:"sector"`
+	BestMillis int64  `json:"bestMillis"`
+}
+
+// StatisticsResults are the lap statistics in a form that can be serialized
+type StatisticsResults struct {
+	Count                   int              `json:"count"`
+	BestLap                 int              `json:"bestLap,omitempty"`
+	BestMillis              int64            `json:"bestMillis,omitempty"`
+	WorstLap                int              `json:"worstLap,omitempty"`
+	WorstMillis             int64            `json:"worstMillis,omitempty"`
+	MeanMillis              int64            `json:"meanMillis,omitempty"`
+	MedianMillis            int64            `json:"medianMillis,omitempty"`
+	StdDevMillis            int64            `json:"stdDevMillis,omitempty"`
+	ConsecutiveLaps         int              `json:"consecutiveLaps"`
+	BestConsecutiveFirstLap int              `json:"bestConsecutiveFirstLap,omitempty"`
+	BestConsecutiveMillis   int64            `json:"bestConsecutiveMillis,omitempty"`
+	TheoreticalBestMillis   int64            `json:"theoreticalBestMillis,omitempty"`
+	Sectors                 []*SectorResults `json:"sectors"`
+}
+
+func (t *Timer) StatisticsResults(consecutiveLaps int) *StatisticsResults {
+	stats := t.Statistics(consecutiveLaps)
+
+	results := &StatisticsResults{
+		Count:           stats.Count,
+		ConsecutiveLaps: stats.ConsecutiveLaps,
+		Sectors:         []*SectorResults{},
+	}
+
+	if stats.Count > 0 {
+		results.BestLap = t.LapNumber(stats.Best)
+		results.BestMillis = t.Duration(stats.Best.Frames()).Milliseconds()
+		results.WorstLap = t.LapNumber(stats.Worst)
+		results.WorstMillis = t.Duration(stats.Worst.Frames()).Milliseconds()
+		results.MeanMillis = stats.Mean.Milliseconds()
+		results.MedianMillis = stats.Median.Milliseconds()
+		results.StdDevMillis = stats.StdDev.Milliseconds()
+	}
+
+	if len(stats.BestConsecutive) > 0 {
+		results.BestConsecutiveFirstLap = t.LapNumber(stats.BestConsecutive[0])
+		results.BestConsecutiveMillis = stats.BestConsecutiveTime.Milliseconds()
+	}
+
+	if theoreticalBest, ok := t.TheoreticalBest(); ok {
+		results.TheoreticalBestMillis = t.Duration(theoreticalBest).Milliseconds()
+	}
+
+	for _, sector := range t.Sectors() {
+		results.Sectors = append(results.Sectors, &SectorResults{
+			Sector:     sector.Key,
+			BestMillis: t.Duration(sector.Best.Frames()).Milliseconds(),
+		})
+	}
+
+	return results
+}
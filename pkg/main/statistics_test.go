@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// testPass is a pass through a gate at a frame
+type testPass struct {
+	gate  string
+	frame uint64
+}
+
+// testTimer times the passes at 100 frames per second, the first gate is the start gate
+func testTimer(track Track, gates []string, passes ...testPass) *Timer {
+	timer := NewTimer(100)
+	timer.Track = track
+	for i, name := range gates {
+		timer.AddGate(i, &Gate{Name: name})
+	}
+	for _, pass := range passes {
+		timer.AddDetection(&Detection{Gate: timer.GatesByName[pass.gate], FrameOffset: pass.frame, Origin: DetectionOriginDetector})
+	}
+	return timer
+}
+
+// testLapsTimer has the laps 1000, 800, 1200 (invalid, the "a" gate is missed), 900 and 800 frames long
+func testLapsTimer() *Timer {
+	return testTimer(Track{"start", "a"}, []string{"start", "a"},
+		testPass{"start", 0}, testPass{"a", 500},
+		testPass{"start", 1000}, testPass{"a", 1400},
+		testPass{"start", 1800},
+		testPass{"start", 3000}, testPass{"a", 3500},
+		testPass{"start", 3900}, testPass{"a", 4200},
+		testPass{"start", 4700})
+}
+
+func TestStatistics(t *testing.T) {
+	timer := testLapsTimer()
+	if len(timer.Laps) != 5 || timer.Laps[2].IsValid() {
+		t.Fatalf("got %d laps, want 5 with lap 3 invalid", len(timer.Laps))
+	}
+
+	stats := timer.Statistics(2)
+
+	if stats.Count != 4 {
+		t.Errorf("count: got %d, want 4", stats.Count)
+	}
+	if number := timer.LapNumber(stats.Best); number != 2 {
+		t.Errorf("best lap: got %d, want 2 (the first of the fastest laps)", number)
+	}
+	if number := timer.LapNumber(stats.Worst); number != 1 {
+		t.Errorf("worst lap: got %d, want 1 (the invalid lap is left out)", number)
+	}
+	if stats.Mean != 8750*time.Millisecond {
+		t.Errorf("mean: got %v, want 8.75s", stats.Mean)
+	}
+	if stats.Median != 8500*time.Millisecond {
+		t.Errorf("median: got %v, want 8.5s", stats.Median)
+	}
+	if want := timer.fractionalDuration(math.Sqrt(6875)); stats.StdDev != want {
+		t.Errorf("standard deviation: got %v, want %v", stats.StdDev, want)
+	}
+}
+
+func TestStatisticsConsecutiveLaps(t *testing.T) {
+	timer := testLapsTimer()
+
+	tests := []struct {
+		name  string
+		laps  int
+		first int
+		time  time.Duration
+	}{
+		// the invalid lap 3 breaks the runs through it
+		{"two laps", 2, 4, 17 * time.Second},
+		{"no run without an invalid lap", 3, 0, 0},
+		{"more laps than flown", 6, 0, 0},
+		{"disabled", 0, 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats := timer.Statistics(test.laps)
+
+			first := 0
+			if len(stats.BestConsecutive) > 0 {
+				first = timer.LapNumber(stats.BestConsecutive[0])
+				if len(stats.BestConsecutive) != test.laps {
+					t.Errorf("got %d laps, want %d", len(stats.BestConsecutive), test.laps)
+				}
+			}
+			if first != test.first || stats.BestConsecutiveTime != test.time {
+				t.Errorf("got laps from %d in %v, want laps from %d in %v", first, stats.BestConsecutiveTime, test.first, test.time)
+			}
+		})
+	}
+}
+
+func TestStatisticsWithoutLaps(t *testing.T) {
+	timer := testTimer(nil, []string{"start"}, testPass{"start", 0})
+
+	stats := timer.Statistics(3)
+	if stats.Count != 0 || stats.Best != nil || stats.Worst != nil || stats.BestConsecutive != nil {
+		t.Errorf("got statistics %+v, want none", stats)
+	}
+}
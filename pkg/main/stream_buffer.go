@@ -6,7 +6,6 @@ package main
 
 import (
 	"errors"
-	"fmt"
 )
 
 type StreamBuffer struct {
@@ -17,6 +16,8 @@ type StreamBuffer struct {
 	_activationFrames   int
 	_inactivationFrames int
 	_lastData           float64
+	_framesSincePeak    int
+	_rejection          string
 }
 
 func NewStreamBuffer(capacity int) *StreamBuffer {
@@ -32,6 +33,8 @@ func NewStreamBuffer(capacity int) *StreamBuffer {
 	return &buffer
 }
 func (s *StreamBuffer) Push(data float64, minActivationValue float64, minActivationFrames int, minInactivationFrames int) (activation *Activation) {
+	s._rejection = ""
+
 	if s._tailPos == -1 && s._headPos == -1 {
 		s._tailPos = 0
 		s._headPos = 0
@@ -41,6 +44,7 @@ func (s *StreamBuffer) Push(data float64, minActivationValue float64, minActivat
 
 	s._headPos = (s._headPos + 1) % len(s.data)
 	s.data[s._headPos] = data
+	s._framesSincePeak += 1
 
 	if data <= 0 {
 		s._inactivationFrames += 1
@@ -52,15 +56,21 @@ func (s *StreamBuffer) Push(data float64, minActivationValue float64, minActivat
 		s._activationFrames += 1
 		s._activationValue += data
 		s._lastData = data
+		s._framesSincePeak = 0
 	}
 
 	if s._inactivationFrames > minInactivationFrames {
 		if s._activationValue >= minActivationValue && s._activationFrames >= minActivationFrames {
 			activation = &Activation{
-				Frames: s._activationFrames,
-				Value:  s._activationValue,
+				Frames:          s._activationFrames,
+				Value:           s._activationValue,
+				PeakValue:       s._lastData,
+				FramesSincePeak: s._framesSincePeak,
 			}
-			fmt.Printf("activation(value: %d, frames: %d), inactivation(frames: %d)\n", int(s._activationValue), s._activationFrames, int(s._inactivationFrames))
+		} else if s._activationValue < minActivationValue && s._activationFrames > 0 {
+			s._rejection = RejectedLowActivationValue
+		} else if s._activationFrames > 0 {
+			s._rejection = RejectedFewActivationFrames
 		}
 
 		s._activationFrames = 0
@@ -75,6 +85,27 @@ func (s *StreamBuffer) Push(data float64, minActivationValue float64, minActivat
 	return activation
 }
 
+// ActivationProgress is the state of the activation being built up in the stream buffer
+type ActivationProgress struct {
+	Value              float64
+	Frames             int
+	InactivationFrames int
+}
+
+// Progress is the state of the activation after the last push
+func (s *StreamBuffer) Progress() ActivationProgress {
+	return ActivationProgress{
+		Value:              s._activationValue,
+		Frames:             s._activationFrames,
+		InactivationFrames: s._inactivationFrames,
+	}
+}
+
+// Rejection is the reason the peak that ended with the last push did not become an activation, if any
+func (s *StreamBuffer) Rejection() string {
+	return s._rejection
+}
+
 func (s *StreamBuffer) Len() int {
 	if s._tailPos == -1 && s._headPos == -1 {
 		return 0
@@ -87,9 +118,16 @@ func (s *StreamBuffer) Len() int {
 	}
 }
 
+// Reset empties the buffer, and drops the activation being built up
 func (s *StreamBuffer) Reset() {
 	s._tailPos = -1
 	s._headPos = -1
+	s._activationValue = 0
+	s._activationFrames = 0
+	s._inactivationFrames = 0
+	s._lastData = 0
+	s._framesSincePeak = 0
+	s._rejection = ""
 }
 
 func (s *StreamBuffer) At(index int) (float64, error) {
@@ -0,0 +1,239 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// how long the last cue is shown when the race was not finished
+const subtitleTailMillis = 10000
+
+// videoSpan is the part of the session timed from one video, the subtitle and chapter times are relative to its start
+type videoSpan struct {
+	Path        string
+	StartMillis int64
+	// 0 for the end of the session
+	StopMillis int64
+}
+
+// clip returns the part of the session times within the video, relative to its start, false when none of it is
+func (s *videoSpan) clip(startMillis int64, stopMillis int64) (int64, int64, bool) {
+	if stopMillis <= s.StartMillis || (s.StopMillis > 0 && startMillis >= s.StopMillis) {
+		return 0, 0, false
+	}
+	if startMillis < s.StartMillis {
+		startMillis = s.StartMillis
+	}
+	if s.StopMillis > 0 && stopMillis > s.StopMillis {
+		stopMillis = s.StopMillis
+	}
+	return startMillis - s.StartMillis, stopMillis - s.StartMillis, true
+}
+
+// subtitleCue is a text shown from StartMillis until StopMillis of the video, while the lap is flown
+type subtitleCue struct {
+	Lap         int
+	StartMillis int64
+	StopMillis  int64
+	Lines       []string
+}
+
+// subtitleCues splits the race into one cue per lap, each showing the current lap, the last lap and the best lap so far.
+// Only the cues within the video are returned, with their times relative to its start.
+func subtitleCues(results *ExportResults, span *videoSpan) []*subtitleCue {
+	var cues []*subtitleCue
+	for _, cue := range raceCues(results) {
+		start, stop, ok := span.clip(cue.StartMillis, cue.StopMillis)
+		if !ok {
+			continue
+		}
+		cues = append(cues, &subtitleCue{Lap: cue.Lap, StartMillis: start, StopMillis: stop, Lines: cue.Lines})
+	}
+	return cues
+}
+
+// raceCues are the cues of the whole session
+func raceCues(results *ExportResults) []*subtitleCue {
+	var cues []*subtitleCue
+	if len(results.Laps) == 0 {
+		return cues
+	}
+
+	start := results.Laps[0].StartMillis
+	if results.HoleshotMillis > 0 {
+		start = results.RaceStartMillis
+	}
+
+	var last *ExportLap
+	var bestMillis int64
+	for i, lap := range results.Laps {
+		stop := lap.StopMillis
+		if i+1 < len(results.Laps) {
+			stop = results.Laps[i+1].StartMillis
+		}
+
+		cues = append(cues, &subtitleCue{
+			Lap:         lap.Number,
+			StartMillis: start,
+			StopMillis:  stop,
+			Lines:       subtitleLines(fmt.Sprintf("Lap %d", lap.Number), last, bestMillis),
+		})
+
+		last = lap
+		if lap.Status == string(LapValid) && (bestMillis == 0 || lap.Millis < bestMillis) {
+			bestMillis = lap.Millis
+		}
+		start = stop
+	}
+
+	title := fmt.Sprintf("Lap %d", last.Number+1)
+	stop := start + subtitleTailMillis
+	if results.FinishMillis > 0 {
+		title = "Finished"
+		stop = results.FinishMillis
+	}
+	if stop > start {
+		cues = append(cues, &subtitleCue{
+			Lap:         last.Number + 1,
+			StartMillis: start,
+			StopMillis:  stop,
+			Lines:       subtitleLines(title, last, bestMillis),
+		})
+	}
+
+	return cues
+}
+
+func subtitleLines(title string, last *ExportLap, bestMillis int64) []string {
+	lines := []string{title}
+	if last != nil {
+		line := fmt.Sprintf("Last lap %s", formatLapTime(last.Millis))
+		if last.Status != string(LapValid) {
+			line += fmt.Sprintf(" (%s)", last.Status)
+		}
+		lines = append(lines, line)
+	}
+	if bestMillis > 0 {
+		lines = append(lines, fmt.Sprintf("Best lap %s", formatLapTime(bestMillis)))
+	}
+	return lines
+}
+
+// formatCueTime formats milliseconds as hh:mm:ss followed by the separator and the milliseconds
+func formatCueTime(millis int64, separator string) string {
+	d := time.Duration(millis) * time.Millisecond
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60, separator, millis%1000)
+}
+
+// exportSRT writes the lap cues as SubRip subtitles
+func exportSRT(w io.Writer, results *ExportResults, span *videoSpan) error {
+	for i, cue := range subtitleCues(results, span) {
+		if _, err := fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1,
+			formatCueTime(cue.StartMillis, ","), formatCueTime(cue.StopMillis, ","), strings.Join(cue.Lines, "\n")); err != nil {
+			return fmt.Errorf("could not write SRT. %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// exportVTT writes the lap cues as WebVTT subtitles
+func exportVTT(w io.Writer, results *ExportResults, span *videoSpan) error {
+	if _, err := fmt.Fprint(w, "WEBVTT\n\n"); err != nil {
+		return fmt.Errorf("could not write WebVTT. %s", err.Error())
+	}
+	for _, cue := range subtitleCues(results, span) {
+		// the ids stay the same when the cues of a playlist session are split between its videos
+		if _, err := fmt.Fprintf(w, "lap-%d\n%s --> %s\n%s\n\n", cue.Lap,
+			formatCueTime(cue.StartMillis, "."), formatCueTime(cue.StopMillis, "."), strings.Join(cue.Lines, "\n")); err != nil {
+			return fmt.Errorf("could not write WebVTT. %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// lapChapterTitle names the chapter of a lap, e.g. "Lap 3 - 0:21.345"
+func lapChapterTitle(lap *ExportLap) string {
+	title := fmt.Sprintf("Lap %d - %s", lap.Number, formatLapTime(lap.Millis))
+	if lap.Status != string(LapValid) {
+		title += fmt.Sprintf(" (%s)", lap.Status)
+	}
+	return title
+}
+
+// escapeFFMetadata escapes the characters that are special in an FFmpeg metadata file
+func escapeFFMetadata(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n").Replace(value)
+}
+
+// exportFFMetadata writes one chapter per lap as an FFmpeg metadata file, to be muxed with
+// ffmpeg -i video.mp4 -i chapters.txt -map_metadata 1 -map_chapters 1 -codec copy out.mp4
+func exportFFMetadata(w io.Writer, results *ExportResults, span *videoSpan) error {
+	var sb strings.Builder
+	sb.WriteString(";FFMETADATA1\n")
+	sb.WriteString(fmt.Sprintf("title=%s\n", escapeFFMetadata(span.Path)))
+	if results.Pilot != "" {
+		sb.WriteString(fmt.Sprintf("artist=%s\n", escapeFFMetadata(results.Pilot)))
+	}
+
+	for _, lap := range results.Laps {
+		start, stop, ok := span.clip(lap.StartMillis, lap.StopMillis)
+		if !ok {
+			continue
+		}
+		sb.WriteString("\n[CHAPTER]\nTIMEBASE=1/1000\n")
+		sb.WriteString(fmt.Sprintf("START=%d\nEND=%d\n", start, stop))
+		sb.WriteString(fmt.Sprintf("title=%s\n", escapeFFMetadata(lapChapterTitle(lap))))
+	}
+
+	if _, err := io.WriteString(w, sb.String()); err != nil {
+		return fmt.Errorf("could not write FFmpeg metadata. %s", err.Error())
+	}
+	return nil
+}
+
+// formatChapterTime formats milliseconds as m:ss, or h:mm:ss from one hour on
+func formatChapterTime(millis int64) string {
+	d := time.Duration(millis) * time.Millisecond
+	if d >= time.Hour {
+		return fmt.Sprintf("%d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+	}
+	return fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+// exportChapters writes one chapter per lap in the plain text format of YouTube video descriptions,
+// which must start with a chapter at 0:00 and have chapters of at least 10 seconds
+func exportChapters(w io.Writer, results *ExportResults, span *videoSpan) error {
+	const minChapterMillis = 10000
+
+	var laps []*ExportLap
+	var starts []int64
+	for _, lap := range results.Laps {
+		if start, _, ok := span.clip(lap.StartMillis, lap.StopMillis); ok {
+			laps = append(laps, lap)
+			starts = append(starts, start)
+		}
+	}
+
+	var sb strings.Builder
+	if len(laps) == 0 || starts[0] >= minChapterMillis {
+		sb.WriteString("0:00 Start\n")
+	}
+	for i, lap := range laps {
+		start := starts[i]
+		if i == 0 && start < minChapterMillis {
+			start = 0
+		}
+		sb.WriteString(fmt.Sprintf("%s %s\n", formatChapterTime(start), lapChapterTitle(lap)))
+	}
+
+	if _, err := io.WriteString(w, sb.String()); err != nil {
+		return fmt.Errorf("could not write chapters. %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormatCueTime(t *testing.T) {
+	tests := []struct {
+		millis    int64
+		separator string
+		want      string
+	}{
+		{0, ",", "00:00:00,000"},
+		{1234, ",", "00:00:01,234"},
+		{61005, ".", "00:01:01.005"},
+		{3723456, ".", "01:02:03.456"},
+	}
+
+	for _, test := range tests {
+		if got := formatCueTime(test.millis, test.separator); got != test.want {
+			t.Errorf("formatCueTime(%d): got %s, want %s", test.millis, got, test.want)
+		}
+	}
+}
+
+// testResults has a valid 10s lap, an invalid 5s lap and a valid 8s lap
+func testResults() *ExportResults {
+	return &ExportResults{
+		Source: "DVR0001.ts",
+		Laps: []*ExportLap{
+			{Number: 1, Millis: 10000, Status: string(LapValid), StartMillis: 2000, StopMillis: 12000},
+			{Number: 2, Millis: 5000, Status: string(LapInvalid), StartMillis: 12000, StopMillis: 17000},
+			{Number: 3, Millis: 8000, Status: string(LapValid), StartMillis: 17000, StopMillis: 25000},
+		},
+	}
+}
+
+func TestSubtitleCues(t *testing.T) {
+	tests := []struct {
+		name    string
+		results func() *ExportResults
+		span    *videoSpan
+		want    []*subtitleCue
+	}{
+		{
+			"unfinished race",
+			testResults,
+			&videoSpan{},
+			[]*subtitleCue{
+				{1, 2000, 12000, []string{"Lap 1"}},
+				{2, 12000, 17000, []string{"Lap 2", "Last lap 0:10.000", "Best lap 0:10.000"}},
+				// the invalid lap is never the best
+				{3, 17000, 25000, []string{"Lap 3", "Last lap 0:05.000 (invalid)", "Best lap 0:10.000"}},
+				{4, 25000, 35000, []string{"Lap 4", "Last lap 0:08.000", "Best lap 0:08.000"}},
+			},
+		},
+		{
+			"finished race with holeshot",
+			func() *ExportResults {
+				results := testResults()
+				results.RaceStartMillis = 500
+				results.HoleshotMillis = 1500
+				results.FinishMillis = 27000
+				return results
+			},
+			&videoSpan{},
+			[]*subtitleCue{
+				{1, 500, 12000, []string{"Lap 1"}},
+				{2, 12000, 17000, []string{"Lap 2", "Last lap 0:10.000", "Best lap 0:10.000"}},
+				{3, 17000, 25000, []string{"Lap 3", "Last lap 0:05.000 (invalid)", "Best lap 0:10.000"}},
+				{4, 25000, 27000, []string{"Finished", "Last lap 0:08.000", "Best lap 0:08.000"}},
+			},
+		},
+		{
+			"second video of a playlist",
+			testResults,
+			&videoSpan{StartMillis: 15000, StopMillis: 30000},
+			[]*subtitleCue{
+				// cut at the start of the video, the best lap is still that of the whole race
+				{2, 0, 2000, []string{"Lap 2", "Last lap 0:10.000", "Best lap 0:10.000"}},
+				{3, 2000, 10000, []string{"Lap 3", "Last lap 0:05.000 (invalid)", "Best lap 0:10.000"}},
+				{4, 10000, 15000, []string{"Lap 4", "Last lap 0:08.000", "Best lap 0:08.000"}},
+			},
+		},
+		{
+			"no laps",
+			func() *ExportResults { return &ExportResults{} },
+			&videoSpan{},
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cues := subtitleCues(test.results(), test.span)
+			if !reflect.DeepEqual(cues, test.want) {
+				for _, cue := range cues {
+					t.Logf("%+v", cue)
+				}
+				t.Errorf("got %d cues, want %d", len(cues), len(test.want))
+			}
+		})
+	}
+}
+
+func TestExportVTTLapIDs(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := exportVTT(&buffer, testResults(), &videoSpan{StartMillis: 15000, StopMillis: 30000}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second video of a playlist starts during lap 2
+	var ids []string
+	for _, line := range strings.Split(buffer.String(), "\n") {
+		if strings.HasPrefix(line, "lap-") {
+			ids = append(ids, line)
+		}
+	}
+	if want := []string{"lap-2", "lap-3", "lap-4"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got cue ids %v, want %v", ids, want)
+	}
+}
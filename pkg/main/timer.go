@@ -4,6 +4,21 @@
 
 package main
 
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	RaceWaiting  = "waiting"
+	RaceRunning  = "running"
+	RaceFinished = "finished"
+)
+
+// Timer is not safe for concurrent use on its own,
+// callers that share it between goroutines (e.g. the HTTP server) must use it within withLock
 type Timer struct {
 	DetectionsInOrder    []*Detection
 	DetectionsByGateName map[string][]*Detection
@@ -11,9 +26,45 @@ type Timer struct {
 	GatesByName          map[string]*Gate
 	Laps                 []*Lap
 	Transitions          []*Transition
+	Corrections          []*Correction
+
+	RaceStart *RaceStart
+	Holeshot  *Holeshot
+	Finished  bool
+
+	// the frame being processed, used as the time of manual commands
+	CurrentFrame uint64
+	// incremented whenever the race, laps, or transitions change
+	Revision uint64
+	// receives the timer events, may be nil
+	Events *EventBus
+	Logger *slog.Logger
+
+	// the gate sequence each lap is validated against, no validation when empty
+	Track Track
+
+	// the videos of a session timed from several videos, nil for a single video
+	Playlist *Playlist
+
+	// when set, detections are ignored until the race is started with StartRace
+	WaitForRaceStart bool
+	// when set, the first lap is timed from the race start instead of from the first start gate pass
+	FirstLapFromStart bool
+
+	// laps longer than AnomalyFactor times the median lap are checked for a missed start gate pass, 0 disables the check
+	AnomalyFactor float64
+	// when set, a lap with a missed start gate pass is split in two at the estimated crossing
+	SplitMissedStartGate bool
+
+	framesPerSec    int
+	finishFrame     uint64
+	lastDetectionID int
+	rebuilding      bool
+
+	mu sync.Mutex
 }
 
-func NewTimer() *Timer {
+func NewTimer(framesPerSec int) *Timer {
 	return &Timer{
 		DetectionsInOrder:    []*Detection{},
 		DetectionsByGateName: map[string][]*Detection{},
@@ -21,23 +72,126 @@ func NewTimer() *Timer {
 		GatesByName:          map[string]*Gate{},
 		Laps:                 []*Lap{},
 		Transitions:          []*Transition{},
+		Corrections:          []*Correction{},
+		Logger:               discardLogger,
+		framesPerSec:         framesPerSec,
 	}
 }
 
-func (t *Timer) AddDetection(detection *Detection) {
+// withLock runs fn while holding the timer lock
+func (t *Timer) withLock(fn func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fn()
+}
 
-	lastDetection := t.LastDetection()
+func (t *Timer) StartRace(frameOffset uint64, source string) bool {
+	if t.RaceStart != nil {
+		return false
+	}
 
-	if lastDetection != nil {
-		//process laps
-		if startGate := t.StartGate(); startGate != nil {
-			if startGate.Name == detection.Gate.Name {
-				if startGateDetections, ok := t.DetectionsByGateName[startGate.Name]; ok && len(startGateDetections) > 0 {
-					t.Laps = append(t.Laps, NewLap(startGateDetections[len(startGateDetections)-1], detection))
-				}
+	t.RaceStart = &RaceStart{
+		FrameOffset: frameOffset,
+		Source:      source,
+	}
+	t.Revision += 1
+	if !t.rebuilding {
+		t.Logger.Info("race started", "frame", frameOffset, "millis", t.Duration(int(frameOffset)).Milliseconds(), "source", source)
+	}
+	t.emit(EventRaceStart, frameOffset, NewSessionRaceStart(t))
+	return true
+}
+
+// AddDetection returns false when the detection is ignored, because the race is not running
+func (t *Timer) AddDetection(detection *Detection) bool {
+
+	if t.WaitForRaceStart && t.RaceStart == nil {
+		// the race has not started yet, ignore detection
+		t.Logger.Debug("detection ignored", "gate", detection.Gate.Name, "frame", detection.FrameOffset, "reason", RejectedRaceWaiting)
+		return false
+	}
+
+	if t.Finished {
+		// the race is over, ignore detection
+		t.Logger.Debug("detection ignored", "gate", detection.Gate.Name, "frame", detection.FrameOffset, "reason", RejectedRaceFinished)
+		return false
+	}
+
+	detection.ID = t.nextDetectionID()
+	t.processDetection(detection)
+
+	if lastLap := t.LastLap(); t.SplitMissedStartGate && lastLap != nil && lastLap.stop == detection && lastLap.missedStartGateFrame != 0 {
+		inferred := &Detection{
+			ID:          t.nextDetectionID(),
+			Gate:        lastLap.Gate(),
+			FrameOffset: lastLap.missedStartGateFrame,
+			Origin:      DetectionOriginInferred,
+		}
+		t.insertDetection(inferred)
+		t.rebuild()
+
+		t.Logger.Info("detection",
+			"id", inferred.ID,
+			"gate", inferred.Gate.Name,
+			"frame", inferred.FrameOffset,
+			"millis", t.Duration(int(inferred.FrameOffset)).Milliseconds(),
+			"origin", inferred.Origin)
+		t.emit(EventDetection, inferred.FrameOffset, NewSessionDetection(t, inferred))
+	}
+
+	t.Logger.Info("detection",
+		"id", detection.ID,
+		"gate", detection.Gate.Name,
+		"frame", detection.FrameOffset,
+		"millis", t.Duration(int(detection.FrameOffset)).Milliseconds(),
+		"origin", detection.Origin)
+	t.emit(EventDetection, detection.FrameOffset, NewSessionDetection(t, detection))
+
+	if lastTransition := t.LastTransition(); lastTransition != nil && lastTransition.stop == detection {
+		t.Logger.Debug("transition", "transition", lastTransition.Name(), "frame", detection.FrameOffset, "millis", t.Duration(lastTransition.Frames()).Milliseconds())
+		t.emit(EventTransition, detection.FrameOffset, NewSessionTransition(t, lastTransition))
+	}
+
+	if lastLap := t.LastLap(); lastLap != nil && lastLap.stop == detection {
+		level := slog.LevelInfo
+		if !lastLap.IsValid() {
+			level = slog.LevelWarn
+		}
+		t.Logger.Log(context.Background(), level, "lap",
+			"lap", t.LapNumber(lastLap),
+			"gate", lastLap.Gate().Name,
+			"frame", detection.FrameOffset,
+			"millis", t.Duration(lastLap.Frames()).Milliseconds(),
+			"status", lastLap.Status,
+			"reason", lastLap.Reason,
+			"inferred", lastLap.HasInferredCrossing())
+		t.emit(EventLap, detection.FrameOffset, NewSessionLap(t, lastLap))
+	}
+
+	return true
+}
+
+func (t *Timer) processDetection(detection *Detection) {
+	t.Revision += 1
+
+	//process laps
+	if startGate := t.StartGate(); startGate != nil && startGate.Name == detection.Gate.Name {
+		if startGateDetections, ok := t.DetectionsByGateName[startGate.Name]; ok && len(startGateDetections) > 0 {
+			t.addLap(NewLap(startGateDetections[len(startGateDetections)-1], detection), detection)
+		} else if t.RaceStart == nil {
+			// without an explicit start, the race starts on the first pass through the start gate
+			t.StartRace(detection.FrameOffset, RaceStartGate)
+		} else {
+			t.Holeshot = NewHoleshot(t.RaceStart, detection)
+			if t.FirstLapFromStart {
+				t.addLap(NewLapFromRaceStart(t.RaceStart, detection), detection)
 			}
 		}
+	}
+
+	lastDetection := t.LastDetection()
 
+	if lastDetection != nil {
 		//process transitions
 		t.Transitions = append(t.Transitions, NewTransition(lastDetection, detection))
 	}
@@ -53,6 +207,176 @@ func (t *Timer) AddDetection(detection *Detection) {
 	t.DetectionsByGateName[detection.Gate.Name] = append(t.DetectionsByGateName[detection.Gate.Name], detection)
 }
 
+// addLap validates the lap against the track, and records it.
+// The detection that finishes the lap has not been added to DetectionsInOrder yet.
+func (t *Timer) addLap(lap *Lap, stop *Detection) {
+	passes := append(t.DetectionsSince(lap.startFrame), stop)
+	lap.Status, lap.Reason = t.Track.Validate(passes)
+
+	if t.Playlist != nil && t.Playlist.HasGap(lap.startFrame, lap.stopFrame) {
+		// the time between the recordings is unknown
+		lap.Status = LapInvalid
+		lap.Reason = "the lap spans a gap between recordings"
+	} else if frame, ok := t.missedStartGateFrame(lap, passes); ok {
+		lap.Status = LapInvalid
+		lap.Reason = "missed start gate pass"
+		lap.missedStartGateFrame = frame
+	}
+
+	t.Laps = append(t.Laps, lap)
+}
+
+func (t *Timer) nextDetectionID() int {
+	t.lastDetectionID += 1
+	return t.lastDetectionID
+}
+
+// insertDetection adds a detection to DetectionsInOrder, keeping the frame order.
+// Laps and transitions must be rebuilt afterwards.
+func (t *Timer) insertDetection(detection *Detection) {
+	index := len(t.DetectionsInOrder)
+	for i, d := range t.DetectionsInOrder {
+		if d.FrameOffset > detection.FrameOffset {
+			index = i
+			break
+		}
+	}
+
+	t.DetectionsInOrder = append(t.DetectionsInOrder, nil)
+	copy(t.DetectionsInOrder[index+1:], t.DetectionsInOrder[index:])
+	t.DetectionsInOrder[index] = detection
+}
+
+// rebuild recomputes laps and transitions from DetectionsInOrder,
+// this is needed whenever a detection is inserted, removed, or moved
+func (t *Timer) rebuild() {
+	detections := t.DetectionsInOrder
+	finished := t.Finished
+	t.rebuilding = true
+
+	t.DetectionsInOrder = []*Detection{}
+	t.DetectionsByGateName = map[string][]*Detection{}
+	t.Laps = []*Lap{}
+	t.Transitions = []*Transition{}
+	t.Holeshot = nil
+	t.Finished = false
+	if t.RaceStart != nil && t.RaceStart.Source == RaceStartGate {
+		t.RaceStart = nil
+	}
+
+	for _, detection := range detections {
+		t.processDetection(detection)
+	}
+
+	if finished {
+		t.FinishRace(t.finishFrame)
+	}
+
+	t.rebuilding = false
+	t.emitState()
+}
+
+// FinishRace ends the race, recording the lap in progress (if any) as incomplete
+func (t *Timer) FinishRace(frameOffset uint64) {
+	if t.Finished {
+		return
+	}
+	t.Finished = true
+	t.finishFrame = frameOffset
+	t.Revision += 1
+	defer func() {
+		if !t.rebuilding {
+			t.Logger.Info("race finished", "frame", frameOffset, "millis", t.Duration(int(frameOffset)).Milliseconds(), "laps", t.LapsCount())
+		}
+		t.emit(EventRaceFinish, frameOffset, NewTimerState(t))
+	}()
+
+	startGate := t.StartGate()
+	if startGate == nil {
+		return
+	}
+
+	var lap *Lap
+	if startGateDetections := t.DetectionsByGateName[startGate.Name]; len(startGateDetections) > 0 {
+		start := startGateDetections[len(startGateDetections)-1]
+		lap = NewIncompleteLap(startGate, start, start.FrameOffset, frameOffset)
+	} else if t.RaceStart != nil && t.FirstLapFromStart {
+		lap = NewIncompleteLap(startGate, nil, t.RaceStart.FrameOffset, frameOffset)
+	}
+
+	if lap == nil || len(t.DetectionsSince(lap.startFrame)) == 0 {
+		// no lap in progress
+		return
+	}
+
+	lap.Reason = "the race finished before the lap was completed"
+	t.Laps = append(t.Laps, lap)
+}
+
+// Reset clears the race, so that a new one can be timed. The corrections log is kept, with the reset added to it.
+func (t *Timer) Reset(author string, reason string) {
+	t.Corrections = append(t.Corrections, &Correction{
+		Time:      time.Now(),
+		Author:    author,
+		Action:    CorrectionReset,
+		FromFrame: t.CurrentFrame,
+		Reason:    reason,
+	})
+
+	t.DetectionsInOrder = []*Detection{}
+	t.DetectionsByGateName = map[string][]*Detection{}
+	t.Laps = []*Lap{}
+	t.Transitions = []*Transition{}
+	t.RaceStart = nil
+	t.Holeshot = nil
+	t.Finished = false
+	t.finishFrame = 0
+	t.Revision += 1
+	t.Logger.Info("race reset", "author", author, "reason", reason)
+	t.emitState()
+}
+
+func (t *Timer) State() string {
+	if t.Finished {
+		return RaceFinished
+	}
+	if t.RaceStart == nil {
+		return RaceWaiting
+	}
+	return RaceRunning
+}
+
+// ResumeRace reopens a finished race, e.g. to continue timing a saved session
+func (t *Timer) ResumeRace() {
+	if !t.Finished {
+		return
+	}
+	t.Finished = false
+	t.Revision += 1
+	t.rebuild()
+}
+
+// DetectionsSince returns the detections after the given frame
+func (t *Timer) DetectionsSince(frameOffset uint64) []*Detection {
+	var detections []*Detection
+	for _, detection := range t.DetectionsInOrder {
+		if detection.FrameOffset > frameOffset {
+			detections = append(detections, detection)
+		}
+	}
+	return detections
+}
+
+func (t *Timer) ValidLaps() []*Lap {
+	var laps []*Lap
+	for _, lap := range t.Laps {
+		if lap.IsValid() {
+			laps = append(laps, lap)
+		}
+	}
+	return laps
+}
+
 func (t *Timer) StartGate() *Gate {
 	if startGate, ok := t.GatesByPosition[0]; ok {
 		return startGate
@@ -102,3 +426,7 @@ func (t *Timer) AddGate(index int, gate *Gate) {
 	t.GatesByName[gate.Name] = gate
 	t.GatesByPosition[index] = gate
 }
+
+func (t *Timer) Duration(frames int) time.Duration {
+	return time.Duration(frames) * time.Second / time.Duration(t.framesPerSec)
+}
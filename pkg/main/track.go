@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Track is the ordered sequence of gate names that make up one lap, starting with the start gate.
+//
+// The same gate may appear more than once, e.g. for figure-8 layouts.
+type Track []string
+
+// Validate checks the gates passed during a lap against the track.
+//
+// The passes are the detections after the lap start, up to and including the pass through the start gate
+// that finished the lap.
+func (t Track) Validate(passes []*Detection) (LapStatus, string) {
+	if len(t) == 0 {
+		return LapValid, ""
+	}
+
+	// after leaving the start gate, the pilot must pass every other gate in order, and then the start gate again
+	expected := append(append([]string{}, t[1:]...), t[0])
+
+	var missed []string
+	var unexpected []string
+	next := 0
+	for _, pass := range passes {
+		found := -1
+		for i := next; i < len(expected); i++ {
+			if expected[i] == pass.Gate.Name {
+				found = i
+				break
+			}
+		}
+
+		if found == -1 {
+			unexpected = append(unexpected, pass.Gate.Name)
+			continue
+		}
+
+		missed = append(missed, expected[next:found]...)
+		next = found + 1
+	}
+	missed = append(missed, expected[next:]...)
+
+	var reasons []string
+	if len(missed) > 0 {
+		reasons = append(reasons, fmt.Sprintf("missed gates: %s", strings.Join(missed, ", ")))
+	}
+	if len(unexpected) > 0 {
+		reasons = append(reasons, fmt.Sprintf("out of sequence gates: %s", strings.Join(unexpected, ", ")))
+	}
+
+	if len(reasons) > 0 {
+		return LapInvalid, strings.Join(reasons, "; ")
+	}
+
+	return LapValid, ""
+}
@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import "testing"
+
+// testPasses are detections of the gates with the given names, in order
+func testPasses(names ...string) []*Detection {
+	var passes []*Detection
+	for _, name := range names {
+		passes = append(passes, &Detection{Gate: &Gate{Name: name}})
+	}
+	return passes
+}
+
+func TestTrackValidate(t *testing.T) {
+	track := Track{"start", "a", "b"}
+	figure8 := Track{"start", "a", "b", "a", "c"}
+
+	tests := []struct {
+		name   string
+		track  Track
+		passes []string
+		status LapStatus
+		reason string
+	}{
+		{"no track", Track{}, []string{"b", "start"}, LapValid, ""},
+		{"valid", track, []string{"a", "b", "start"}, LapValid, ""},
+		{"missed gate", track, []string{"a", "start"}, LapInvalid, "missed gates: b"},
+		{"missed all gates", track, []string{"start"}, LapInvalid, "missed gates: a, b"},
+		{"out of order", track, []string{"b", "a", "start"}, LapInvalid, "missed gates: a; out of sequence gates: a"},
+		{"repeated gate", track, []string{"a", "a", "b", "start"}, LapInvalid, "out of sequence gates: a"},
+		{"unknown gate", track, []string{"a", "x", "b", "start"}, LapInvalid, "out of sequence gates: x"},
+		{"incomplete", track, []string{"a"}, LapInvalid, "missed gates: b, start"},
+		{"figure-8 valid", figure8, []string{"a", "b", "a", "c", "start"}, LapValid, ""},
+		{"figure-8 missed repeat", figure8, []string{"a", "b", "c", "start"}, LapInvalid, "missed gates: a"},
+		{"figure-8 missed first pass", figure8, []string{"b", "a", "c", "start"}, LapInvalid, "missed gates: a"},
+		{"figure-8 extra repeat", figure8, []string{"a", "b", "a", "a", "c", "start"}, LapInvalid, "out of sequence gates: a"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, reason := test.track.Validate(testPasses(test.passes...))
+			if status != test.status || reason != test.reason {
+				t.Errorf("got %s %q, want %s %q", status, reason, test.status, test.reason)
+			}
+		})
+	}
+}
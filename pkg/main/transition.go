@@ -23,3 +23,8 @@ func (t *Transition) Frames() int {
 func (t *Transition) Gate() *Gate {
 	return t.start.Gate
 }
+
+// Name identifies the sector of the track covered by the transition, e.g. "pink->green"
+func (t *Transition) Name() string {
+	return t.start.Gate.Name + "->" + t.stop.Gate.Name
+}
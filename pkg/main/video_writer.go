@@ -0,0 +1,182 @@
+// SPDX-FileCopyrightText: © 2023 OneEyeFPV oneeyefpv@gmail.com
+// SPDX-License-Identifier: GPL-3.0-or-later
+// SPDX-License-Identifier: FS-0.9-or-later
+
+package main
+
+import (
+	"fmt"
+	"gocv.io/x/gocv"
+	"image"
+	"image/color"
+)
+
+// graphSeconds is how much of the gate area signal is visible in the graph
+const graphSeconds = 5
+
+// AnnotatedVideoWriter writes a copy of the input video with the overlay, detections, and optionally the
+// binary mask and the gate area signal drawn on it, so that reviewed runs can be shared
+type AnnotatedVideoWriter struct {
+	path        string
+	showMask    bool
+	showGraph   bool
+	flashFrames int
+	gates       []*Gate
+	gateColors  map[*Gate]color.RGBA
+
+	_writer          *gocv.VideoWriter
+	_frame           gocv.Mat
+	_mask            gocv.Mat
+	_maskResized     gocv.Mat
+	_graphBackground gocv.Mat
+	_maskRect        image.Rectangle
+	_graphRect       image.Rectangle
+
+	_flash           *Detection
+	_flashFramesLeft int
+
+	// gate area history, oldest first
+	_areas map[*Gate][]int
+}
+
+func NewAnnotatedVideoWriter(path string, codec string, framesPerSec int, width int, height int,
+	showMask bool,
+	showGraph bool,
+	flashMillis int,
+	gates []*Gate,
+	gateColors []color.RGBA) (*AnnotatedVideoWriter, error) {
+
+	writer, err := gocv.VideoWriterFile(path, codec, float64(framesPerSec), width, height, true)
+	if err != nil {
+		return nil, fmt.Errorf("could not create output video %s. %s", path, err.Error())
+	}
+
+	maskWidth := width / 4
+	maskHeight := maskWidth * 3 / 4
+	graphWidth := width / 3
+	graphHeight := height / 5
+
+	w := &AnnotatedVideoWriter{
+		path:        path,
+		showMask:    showMask,
+		showGraph:   showGraph,
+		flashFrames: flashMillis * framesPerSec / 1000,
+		gates:       gates,
+		gateColors:  map[*Gate]color.RGBA{},
+
+		_writer:          writer,
+		_frame:           gocv.NewMat(),
+		_mask:            gocv.NewMat(),
+		_maskResized:     gocv.NewMat(),
+		_graphBackground: gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), graphHeight, graphWidth, gocv.MatTypeCV8UC3),
+		_maskRect:        image.Rect(width-maskWidth-20, 20, width-20, 20+maskHeight),
+		_graphRect:       image.Rect(20, height-graphHeight-20, 20+graphWidth, height-20),
+
+		_areas: map[*Gate][]int{},
+	}
+
+	for i, gate := range gates {
+		w.gateColors[gate] = gateColors[i]
+		w._areas[gate] = make([]int, graphSeconds*framesPerSec)
+	}
+
+	return w, nil
+}
+
+// Write draws the annotations on a copy of the frame, and appends it to the output video.
+// The detection is the one accepted by the timer in this frame, if any.
+func (w *AnnotatedVideoWriter) Write(img gocv.Mat, overlay *Overlay, detector *Detector, detection *Detection) error {
+	img.CopyTo(&w._frame)
+
+	overlay.Draw(&w._frame, 0)
+
+	if detection != nil {
+		w._flash = detection
+		w._flashFramesLeft = w.flashFrames
+	}
+	if w._flashFramesLeft > 0 {
+		w.drawFlash()
+		w._flashFramesLeft -= 1
+	}
+
+	if w.showMask {
+		w.drawMask(detector.BinaryImage())
+	}
+
+	if w.showGraph {
+		for _, gate := range w.gates {
+			w._areas[gate] = append(w._areas[gate][1:], detector.GateArea(gate))
+		}
+		w.drawGraph()
+	}
+
+	if err := w._writer.Write(w._frame); err != nil {
+		return fmt.Errorf("could not write output video %s. %s", w.path, err.Error())
+	}
+	return nil
+}
+
+// drawFlash frames the picture in the color of the detected gate
+func (w *AnnotatedVideoWriter) drawFlash() {
+	gateColor := w.gateColors[w._flash.Gate]
+	bounds := image.Rect(0, 0, w._frame.Cols(), w._frame.Rows())
+	gocv.Rectangle(&w._frame, bounds, gateColor, 16)
+	gocv.PutText(&w._frame, w._flash.Gate.Name, image.Pt(40, 60), gocv.FontHersheyDuplex, 1.5, gateColor, 2)
+}
+
+// drawMask puts the binary mask in the top right corner
+func (w *AnnotatedVideoWriter) drawMask(mask gocv.Mat) {
+	gocv.CvtColor(mask, &w._mask, gocv.ColorGrayToBGR)
+	gocv.Resize(w._mask, &w._maskResized, w._maskRect.Size(), 0, 0, gocv.InterpolationNearestNeighbor)
+
+	region := w._frame.Region(w._maskRect)
+	w._maskResized.CopyTo(&region)
+	_ = region.Close()
+
+	gocv.Rectangle(&w._frame, w._maskRect, color.RGBA{R: 255, G: 255, B: 255}, 1)
+}
+
+// drawGraph plots the area of each gate marker over the last seconds in the bottom left corner
+func (w *AnnotatedVideoWriter) drawGraph() {
+	region := w._frame.Region(w._graphRect)
+	gocv.AddWeighted(region, 0.4, w._graphBackground, 0.6, 0, &region)
+	_ = region.Close()
+
+	maxArea := 1
+	for _, areas := range w._areas {
+		for _, area := range areas {
+			if area > maxArea {
+				maxArea = area
+			}
+		}
+	}
+
+	width := w._graphRect.Dx()
+	height := w._graphRect.Dy()
+	for _, gate := range w.gates {
+		areas := w._areas[gate]
+		var previous image.Point
+		for i, area := range areas {
+			point := w._graphRect.Min.Add(image.Pt(i*width/len(areas), height-area*height/maxArea))
+			if i > 0 {
+				gocv.Line(&w._frame, previous, point, w.gateColors[gate], 2)
+			}
+			previous = point
+		}
+	}
+
+	gocv.Rectangle(&w._frame, w._graphRect, color.RGBA{R: 255, G: 255, B: 255}, 1)
+	gocv.PutText(&w._frame, fmt.Sprintf("max area: %d", maxArea), w._graphRect.Min.Add(image.Pt(5, 20)), gocv.FontHersheyDuplex, 0.6, color.RGBA{R: 255, G: 255, B: 255}, 1)
+}
+
+func (w *AnnotatedVideoWriter) Close() error {
+	_ = w._frame.Close()
+	_ = w._mask.Close()
+	_ = w._maskResized.Close()
+	_ = w._graphBackground.Close()
+
+	if err := w._writer.Close(); err != nil {
+		return fmt.Errorf("could not close output video %s. %s", w.path, err.Error())
+	}
+	return nil
+}